@@ -0,0 +1,210 @@
+// kingsmoot-exec joins an election and runs a command only while it is the leader, so that
+// services which can not embed kingsmoot take part in leader election unchanged.
+//
+//	kingsmoot-exec -name akem -addresses http://localhost:2379 [-follower "command"] -- command [args...]
+//
+// The leader command is started on becoming Leader and stopped with SIGTERM, then SIGKILL
+// after -grace, on losing leadership. The lease safety margin is raised to cover -grace, so
+// that the command of a leader cut off from the datastore is killed before another node
+// can take over. The follower command, if any, is run through sh -c while Follower. If
+// the leader command exits on its own, leadership is stepped down from.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+	"kingsmoot"
+)
+
+func main() {
+	conf := kingsmoot.DefaultConfig()
+	conf.RegisterFlags(flag.CommandLine)
+	hostname, _ := os.Hostname()
+	endpoint := flag.String("endpoint", hostname, "Endpoint of this node, written into the leader key")
+	priority := flag.Int("priority", 0, "Priority of this node in the election")
+	follower := flag.String("follower", "", "Command to run through sh -c while follower")
+	grace := flag.Duration("grace", 10*time.Second, "How long a command has to exit after SIGTERM before it is killed")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on /metrics and the status of the node under /kingsmoot/, none if empty")
+	flag.Parse()
+	if conf.Name == "" || len(conf.Addresses) == 0 || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: kingsmoot-exec -name <service> -addresses <urls> [flags] -- command [args...]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	margin, err := leaseSafetyMargin(conf, *grace)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	conf.LeaseSafetyMargin = margin
+	logger := kingsmoot.NewStdLogger(nil, log.New(os.Stderr, "INFO: ", log.LstdFlags),
+		log.New(os.Stderr, "WARNING: ", log.LstdFlags), log.New(os.Stderr, "ERROR: ", log.LstdFlags))
+	conf.Logger = logger
+
+	km, err := kingsmoot.NewFromConf(conf)
+	if err != nil {
+		logger.Errorf("Failed to connect to datastore: %v", err)
+		os.Exit(1)
+	}
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", kingsmoot.MetricsHandler())
+		mux.Handle("/kingsmoot/", http.StripPrefix("/kingsmoot", km.Handler()))
+		go func() {
+			logger.Errorf("Metrics server stopped: %v", http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
+	c := &execCandidate{
+		endpoint: *endpoint,
+		leader:   flag.Args(),
+		grace:    *grace,
+		logger:   logger,
+		onLeaderExit: func() {
+			km.StepDown(context.Background())
+		}}
+	if *follower != "" {
+		c.follower = []string{"sh", "-c", *follower}
+	}
+	if err := km.Join(*endpoint, c, kingsmoot.WithPriority(*priority)); err != nil {
+		logger.Errorf("Failed to join election %v: %v", conf.Name, err)
+		os.Exit(1)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Infof("Received %v, exiting", sig)
+	c.stop()
+	km.Exit()
+}
+
+// leaseSafetyMargin raises the LeaseSafetyMargin of conf to grace plus DsOpTimeout, so
+// that a leader which can not refresh the leader key has killed its command before the
+// key expires and another node starts its own
+func leaseSafetyMargin(conf *kingsmoot.Config, grace time.Duration) (time.Duration, error) {
+	margin := conf.LeaseSafetyMargin
+	if margin <= 0 {
+		margin = conf.MasterDownAfter / 10
+	}
+	if min := grace + conf.DsOpTimeout; margin < min {
+		margin = min
+	}
+	if margin >= conf.MasterDownAfter {
+		return 0, errors.New(fmt.Sprintf("-grace %v plus -ds-op-timeout %v must be below -master-down-after %v", grace, conf.DsOpTimeout, conf.MasterDownAfter))
+	}
+	return margin, nil
+}
+
+// execCandidate runs the leader command while Leader and the follower command while
+// Follower, one of them at a time
+type execCandidate struct {
+	endpoint     string
+	leader       []string
+	follower     []string
+	grace        time.Duration
+	logger       kingsmoot.Logger
+	onLeaderExit func()
+	mu           sync.Mutex //Protects everything below
+	role         kingsmoot.Role
+	stopped      bool //Set by stop, no command is started after it
+	cmd          *exec.Cmd
+	exited       chan struct{} //Closed once cmd has exited
+}
+
+func (c *execCandidate) UpdateMembership(memberShip kingsmoot.MemberShip) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return errors.New(fmt.Sprintf("%v is shutting down", c.endpoint))
+	}
+	prevRole := c.role
+	c.role = memberShip.Role
+	if prevRole == memberShip.Role && c.cmd != nil {
+		return nil
+	}
+	c.stopLocked()
+	switch memberShip.Role {
+	case kingsmoot.Leader:
+		return c.startLocked(c.leader, true)
+	case kingsmoot.Follower:
+		if c.follower != nil {
+			return c.startLocked(c.follower, false)
+		}
+	}
+	return nil
+}
+
+// startLocked starts args, must be called with c.mu held
+func (c *execCandidate) startLocked(args []string, leader bool) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, os.Stdout, os.Stderr
+	// In a process group of its own, so that its children are signalled along with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return errors.New(fmt.Sprintf("Failed to start [%v] due to %v", strings.Join(args, " "), err))
+	}
+	c.logger.Infof("Started [%v] with pid %v", strings.Join(args, " "), cmd.Process.Pid)
+	exited := make(chan struct{})
+	c.cmd, c.exited = cmd, exited
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		c.mu.Lock()
+		current := c.cmd == cmd
+		if current {
+			c.cmd, c.exited = nil, nil
+		}
+		c.mu.Unlock()
+		if !current {
+			return
+		}
+		c.logger.Warnf("[%v] exited on its own: %v", strings.Join(args, " "), err)
+		if leader {
+			c.onLeaderExit()
+		}
+	}()
+	return nil
+}
+
+// stop stops the running command for good, a membership update coming in till the
+// Kingsmoot has exited does not start another
+func (c *execCandidate) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	c.stopLocked()
+}
+
+// stopLocked sends SIGTERM to the process group of the running command, and SIGKILL if it
+// has not exited after the grace period. Must be called with c.mu held.
+func (c *execCandidate) stopLocked() {
+	cmd, exited := c.cmd, c.exited
+	if cmd == nil {
+		return
+	}
+	c.cmd, c.exited = nil, nil
+	c.logger.Infof("Stopping pid %v", cmd.Process.Pid)
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(c.grace):
+		c.logger.Warnf("pid %v did not exit within %v, killing it", cmd.Process.Pid, c.grace)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	}
+}
+
+func (c *execCandidate) String() string {
+	return c.endpoint
+}
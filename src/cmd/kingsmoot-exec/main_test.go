@@ -0,0 +1,101 @@
+package main
+
+import (
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+func newTestCandidate(leader []string, onLeaderExit func()) *execCandidate {
+	return &execCandidate{
+		endpoint:     "akem1:6379",
+		leader:       leader,
+		follower:     []string{"sh", "-c", "trap '' TERM; sleep 30"},
+		grace:        200 * time.Millisecond,
+		logger:       kingsmoot.NopLogger(),
+		onLeaderExit: onLeaderExit}
+}
+
+func (c *execCandidate) running() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cmd != nil
+}
+
+func TestExecCandidate(t *testing.T) {
+	c := newTestCandidate([]string{"sleep", "30"}, func() { t.Error("Leader command should not have exited on its own") })
+	if err := c.UpdateMembership(kingsmoot.MemberShip{Role: kingsmoot.Leader}); err != nil {
+		t.Fatal("1:Failed to start leader command", err)
+	}
+	c.mu.Lock()
+	leader := c.exited
+	c.mu.Unlock()
+	if err := c.UpdateMembership(kingsmoot.MemberShip{Role: kingsmoot.Follower, Leader: "akem2:6379"}); err != nil {
+		t.Fatal("2:Failed to start follower command", err)
+	}
+	select {
+	case <-leader:
+	default:
+		t.Fatal("3:Leader command should have been stopped on becoming follower")
+	}
+	if !c.running() {
+		t.Fatal("4:Follower command should have been running")
+	}
+	// Let the shell set its trap up
+	<-time.After(100 * time.Millisecond)
+	start := time.Now()
+	c.UpdateMembership(kingsmoot.MemberShip{Role: kingsmoot.NotAMember})
+	if elapsed := time.Since(start); elapsed < c.grace || c.running() {
+		t.Fatalf("5:Follower command ignoring SIGTERM should have been killed after %v, took %v", c.grace, elapsed)
+	}
+}
+
+func TestLeaderCommandExit(t *testing.T) {
+	exited := make(chan bool, 1)
+	c := newTestCandidate([]string{"true"}, func() { exited <- true })
+	if err := c.UpdateMembership(kingsmoot.MemberShip{Role: kingsmoot.Leader}); err != nil {
+		t.Fatal("1:Failed to start leader command", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("2:Exit of the leader command should have been told")
+	}
+	c = newTestCandidate([]string{"/nonexistent/command"}, nil)
+	if err := c.UpdateMembership(kingsmoot.MemberShip{Role: kingsmoot.Leader}); err == nil {
+		t.Fatal("3:Leader should have failed to start")
+	}
+}
+
+func TestStop(t *testing.T) {
+	c := newTestCandidate([]string{"sleep", "30"}, func() { t.Error("Leader command should not have exited on its own") })
+	if err := c.UpdateMembership(kingsmoot.MemberShip{Role: kingsmoot.Leader}); err != nil {
+		t.Fatal("1:Failed to start leader command", err)
+	}
+	c.stop()
+	if c.running() {
+		t.Fatal("2:Leader command should have been stopped")
+	}
+	// The Kingsmoot may still elect the node before it exits
+	if err := c.UpdateMembership(kingsmoot.MemberShip{Role: kingsmoot.Leader, Term: 2}); err == nil {
+		t.Fatal("3:Leader should have been refused after stop")
+	}
+	if c.running() {
+		t.Fatal("4:Leader command should not have been started after stop")
+	}
+}
+
+func TestLeaseSafetyMargin(t *testing.T) {
+	conf := kingsmoot.DefaultConfig()
+	margin, err := leaseSafetyMargin(conf, 10*time.Second)
+	if err != nil || margin != 10*time.Second+conf.DsOpTimeout {
+		t.Fatalf("1:Expected margin to cover grace Got %v %v", margin, err)
+	}
+	margin, err = leaseSafetyMargin(conf, time.Second)
+	if err != nil || margin != conf.MasterDownAfter/10 {
+		t.Fatalf("2:Expected default margin Got %v %v", margin, err)
+	}
+	if _, err = leaseSafetyMargin(conf, conf.MasterDownAfter); err == nil {
+		t.Fatal("3:Grace beyond master-down-after should have been rejected")
+	}
+}
@@ -0,0 +1,172 @@
+// kingsmootctl inspects and operates the election of a service through kingsmoot, rather
+// than through the keys it keeps in the datastore.
+//
+//	kingsmootctl -name akem -addresses http://localhost:2379 [-json] <command>
+//
+// Commands are
+//
+//	leader           shows the current leader
+//	watch            prints the leader on every change of it, till interrupted
+//	members          lists the candidates which joined the election, marking the leader
+//	evict <endpoint> deletes the leader key if endpoint leads, forcing another election
+//
+// The exit status is 1 if the command failed, or if there is no leader to show, and 2 on
+// wrong usage.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+	"kingsmoot"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command given by args, writing its output to stdout and failures to
+// stderr, and returns the exit status
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	conf := kingsmoot.DefaultConfig()
+	conf.Logger = kingsmoot.NopLogger()
+	fs := flag.NewFlagSet("kingsmootctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	conf.RegisterFlags(fs)
+	asJSON := fs.Bool("json", false, "Print JSON, one document per line, for scripting")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cmd := fs.Args()
+	if conf.Name == "" || len(conf.Addresses) == 0 || len(cmd) == 0 || (cmd[0] == "evict") != (len(cmd) == 2) || len(cmd) > 2 {
+		fmt.Fprintln(stderr, "usage: kingsmootctl -name <service> -addresses <urls> [flags] leader|watch|members|evict <endpoint>")
+		fs.PrintDefaults()
+		return 2
+	}
+	km, err := kingsmoot.NewFromConfContext(ctx, conf)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to connect to datastore: %v\n", err)
+		return 1
+	}
+	defer km.Exit()
+	p := &printer{w: stdout, json: *asJSON}
+	switch cmd[0] {
+	case "leader":
+		err = leader(ctx, km, p)
+	case "watch":
+		err = watch(ctx, km, p)
+	case "members":
+		err = members(ctx, km, p)
+	case "evict":
+		err = km.EvictContext(ctx, cmd[1])
+		if err == nil {
+			p.line(map[string]string{"evicted": cmd[1]}, "Evicted %v from leadership of %v", cmd[1], conf.Name)
+		}
+	default:
+		fmt.Fprintf(stderr, "Unknown command %v\n", cmd[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%v of %v failed: %v\n", cmd[0], conf.Name, err)
+		return 1
+	}
+	return 0
+}
+
+// leaderRecord is the JSON of a LeaderRecord, term included
+type leaderRecord struct {
+	kingsmoot.LeaderRecord
+	Term uint64 `json:"term"`
+}
+
+func leader(ctx context.Context, km *kingsmoot.Kingsmoot, p *printer) error {
+	record, err := km.LeaderRecordContext(ctx)
+	if err != nil {
+		if err.(kingsmoot.Error).Code() == kingsmoot.KeyNotFound {
+			return errors.New("No leader")
+		}
+		return err
+	}
+	p.record(record)
+	return nil
+}
+
+func watch(ctx context.Context, km *kingsmoot.Kingsmoot, p *printer) error {
+	if err := km.WatchLeader(ctx, p.record); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// member is the JSON of a Member, telling if it is the leader
+type member struct {
+	kingsmoot.Member
+	Leader bool `json:"leader"`
+}
+
+func members(ctx context.Context, km *kingsmoot.Kingsmoot, p *printer) error {
+	ms, err := km.MembersContext(ctx)
+	if err != nil {
+		return err
+	}
+	leader, err := km.LeaderContext(ctx)
+	if err != nil && err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		return err
+	}
+	if p.json {
+		out := make([]member, 0, len(ms))
+		for _, m := range ms {
+			out = append(out, member{Member: m, Leader: m.Endpoint == leader})
+		}
+		p.line(out, "")
+		return nil
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENDPOINT\tLEADER\tPRIORITY\tHOSTNAME\tPID\tJOINED")
+	for _, m := range ms {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", m.Endpoint, m.Endpoint == leader, m.Priority, m.Hostname, m.Pid, m.JoinedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// printer writes either JSON or text, one line per call
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func (p *printer) line(v interface{}, format string, args ...interface{}) {
+	if p.json {
+		b, _ := json.Marshal(v)
+		fmt.Fprintln(p.w, string(b))
+		return
+	}
+	fmt.Fprintf(p.w, format+"\n", args...)
+}
+
+// record prints the leader in record, or that there is none if it is empty
+func (p *printer) record(record kingsmoot.LeaderRecord) {
+	if record.Endpoint == "" {
+		p.line(map[string]interface{}{"endpoint": nil}, "No leader")
+		return
+	}
+	p.line(leaderRecord{LeaderRecord: record, Term: record.Term}, "%v term %v since %v on %v pid %v",
+		record.Endpoint, record.Term, record.AcquiredAt.Format(time.RFC3339), record.Hostname, record.Pid)
+}
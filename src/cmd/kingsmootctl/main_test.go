@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"kingsmoot"
+)
+
+type testCandidate struct {
+	endpoint string
+	roleCh   chan kingsmoot.Role
+}
+
+func (c *testCandidate) UpdateMembership(memberShip kingsmoot.MemberShip) error {
+	c.roleCh <- memberShip.Role
+	return nil
+}
+
+func (c *testCandidate) String() string {
+	return c.endpoint
+}
+
+func join(t *testing.T, name string, endpoint string) (*kingsmoot.Kingsmoot, *testCandidate) {
+	conf := kingsmoot.DefaultConfig()
+	conf.Name, conf.DataStoreType, conf.Addresses, conf.MasterDownAfter = "akem", "memory", []string{name}, time.Second
+	conf.Logger = kingsmoot.NopLogger()
+	km, err := kingsmoot.NewFromConf(conf)
+	if err != nil {
+		t.Fatal("Failed to create kingsmoot", err)
+	}
+	c := &testCandidate{endpoint: endpoint, roleCh: make(chan kingsmoot.Role, 16)}
+	if err := km.Join(endpoint, c); err != nil {
+		t.Fatal("Failed to join leader election", err)
+	}
+	return km, c
+}
+
+func awaitRole(t *testing.T, c *testCandidate, expected kingsmoot.Role, step string) {
+	select {
+	case role := <-c.roleCh:
+		if role != expected {
+			t.Fatalf("%v:Expected %v to be %v Got %v", step, c, expected, role)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("%v:%v did not become %v", step, c, expected)
+	}
+}
+
+func ctl(t *testing.T, args ...string) (int, string) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-name", "akem", "-datastore", "memory", "-addresses", t.Name(), "-master-down-after", "1s"}, args...)
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String() + stderr.String()
+}
+
+func TestCtl(t *testing.T) {
+	if code, out := ctl(t, "leader"); code != 1 || !strings.Contains(out, "No leader") {
+		t.Fatalf("1:Expected no leader Got %v %v", code, out)
+	}
+	km1, c1 := join(t, t.Name(), "akem1:6379")
+	defer km1.Exit()
+	awaitRole(t, c1, kingsmoot.Leader, "2")
+	km2, c2 := join(t, t.Name(), "akem2:6379")
+	defer km2.Exit()
+	awaitRole(t, c2, kingsmoot.Follower, "3")
+
+	code, out := ctl(t, "-json", "leader")
+	var record leaderRecord
+	if code != 0 || json.Unmarshal([]byte(out), &record) != nil || record.Endpoint != c1.endpoint || record.Term == 0 {
+		t.Fatalf("4:Expected leader %v Got %v %v", c1, code, out)
+	}
+	code, out = ctl(t, "-json", "members")
+	var ms []member
+	if code != 0 || json.Unmarshal([]byte(out), &ms) != nil || len(ms) != 2 || !ms[0].Leader || ms[1].Leader {
+		t.Fatalf("5:Expected members %v and %v Got %v %v", c1, c2, code, out)
+	}
+	if code, out = ctl(t, "members"); code != 0 || !strings.Contains(out, "akem2:6379  false") {
+		t.Fatalf("6:Expected a table of members Got %v %v", code, out)
+	}
+	if code, out = ctl(t, "evict", c2.endpoint); code != 1 || !strings.Contains(out, "CompareFailed") {
+		t.Fatalf("7:Evicting a follower should fail Got %v %v", code, out)
+	}
+	if code, out = ctl(t, "evict"); code != 2 {
+		t.Fatalf("8:Evict without endpoint should be wrong usage Got %v %v", code, out)
+	}
+	if code, out = ctl(t, "evict", c1.endpoint); code != 0 {
+		t.Fatalf("9:Failed to evict %v Got %v %v", c1, code, out)
+	}
+}
+
+// syncBuffer is a bytes.Buffer written by the goroutine of a watch while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCtlWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var stdout, stderr syncBuffer
+	done := make(chan int)
+	go func() {
+		done <- run(ctx, []string{"-name", "akem", "-datastore", "memory", "-addresses", t.Name(), "watch"}, &stdout, &stderr)
+	}()
+	<-time.After(50 * time.Millisecond)
+	km1, c1 := join(t, t.Name(), "akem1:6379")
+	awaitRole(t, c1, kingsmoot.Leader, "1")
+	<-time.After(50 * time.Millisecond)
+	km1.Exit()
+	<-time.After(50 * time.Millisecond)
+	cancel()
+	if code := <-done; code != 0 {
+		t.Fatalf("2:Watch failed with %v %v", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || lines[0] != "No leader" || !strings.HasPrefix(lines[1], "akem1:6379 term ") || lines[2] != "No leader" {
+		t.Fatalf("3:Unexpected changes of leader %q", lines)
+	}
+}
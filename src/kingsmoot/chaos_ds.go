@@ -0,0 +1,285 @@
+package kingsmoot
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ChaosDataStore wraps a DataStore and injects faults into it, for testing how Candidates
+// behave when the datastore misbehaves. Faults are injected before the operation reaches
+// the wrapped DataStore, so a failed operation never took effect. Give every node of a
+// test a ChaosDataStore of its own, by passing it to NewFromDataStore, to partition nodes
+// one at a time.
+type ChaosDataStore struct {
+	DataStore
+	clock       Clock
+	mu          sync.Mutex //Protects everything below
+	rand        *rand.Rand
+	latency     time.Duration
+	faults      map[string]chaosFault //By op, "" for every op
+	dropRate    float64
+	eventDelay  time.Duration
+	partitioned bool
+	listeners   map[int]*chaosListener
+	nextID      int
+}
+
+type chaosFault struct {
+	code ErrorCode
+	rate float64
+}
+
+// ChaosOption changes how a ChaosDataStore injects faults
+type ChaosOption func(cds *ChaosDataStore)
+
+// ChaosClock delays operations and changes by clock, the time of the system by default
+func ChaosClock(clock Clock) ChaosOption {
+	return func(cds *ChaosDataStore) {
+		cds.clock = clock
+	}
+}
+
+// ChaosSeed seeds what decides which operations fail and which changes are dropped, so
+// that a test injecting faults at a rate can be run again the same way. Seeded by the time
+// of the system by default.
+func ChaosSeed(seed int64) ChaosOption {
+	return func(cds *ChaosDataStore) {
+		cds.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+func NewChaosDataStore(ds DataStore, opts ...ChaosOption) *ChaosDataStore {
+	cds := &ChaosDataStore{DataStore: ds, clock: realClock{}, rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		faults: make(map[string]chaosFault), listeners: make(map[int]*chaosListener)}
+	for _, opt := range opts {
+		opt(cds)
+	}
+	return cds
+}
+
+// SetLatency delays every operation by d, or till its ctx is done
+func (cds *ChaosDataStore) SetLatency(d time.Duration) {
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+	cds.latency = d
+}
+
+// Fail fails the given ops, every op if none is given, with code at rate, from 0 for never
+// to 1 for always. Ops are named after the methods of DataStore, Close aside.
+func (cds *ChaosDataStore) Fail(code ErrorCode, rate float64, ops ...string) {
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+	if len(ops) == 0 {
+		ops = []string{""}
+	}
+	for _, op := range ops {
+		cds.faults[op] = chaosFault{code: code, rate: rate}
+	}
+}
+
+// DropEvents drops changes seen by watches at rate, from 0 for never to 1 for always
+func (cds *ChaosDataStore) DropEvents(rate float64) {
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+	cds.dropRate = rate
+}
+
+// DelayEvents holds every change seen by watches back for d, keeping them in order
+func (cds *ChaosDataStore) DelayEvents(d time.Duration) {
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+	cds.eventDelay = d
+}
+
+// ByeWatches ends every watch running, telling its Listener err
+func (cds *ChaosDataStore) ByeWatches(err error) {
+	cds.mu.Lock()
+	listeners := make([]*chaosListener, 0, len(cds.listeners))
+	for _, cl := range cds.listeners {
+		listeners = append(listeners, cl)
+	}
+	cds.mu.Unlock()
+	for _, cl := range listeners {
+		if cl.end() {
+			cl.l.Bye(err)
+		}
+	}
+}
+
+// Partition cuts the node off the datastore: every watch ends and every operation fails
+// with DataStoreError till Heal
+func (cds *ChaosDataStore) Partition() {
+	cds.mu.Lock()
+	cds.partitioned = true
+	cds.mu.Unlock()
+	cds.ByeWatches(&OpError{code: DataStoreError, op: "Watch", cause: errors.New("Partitioned from the datastore")})
+}
+
+// Heal takes back every fault injected so far
+func (cds *ChaosDataStore) Heal() {
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+	cds.latency, cds.dropRate, cds.eventDelay, cds.partitioned = 0, 0, 0, false
+	cds.faults = make(map[string]chaosFault)
+}
+
+// inject applies the latency and faults set for op
+func (cds *ChaosDataStore) inject(ctx context.Context, op string) error {
+	cds.mu.Lock()
+	latency, partitioned := cds.latency, cds.partitioned
+	fault, ok := cds.faults[op]
+	if !ok {
+		fault, ok = cds.faults[""]
+	}
+	failed := ok && cds.rand.Float64() < fault.rate
+	cds.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-cds.clock.After(latency):
+		case <-ctx.Done():
+			return ctxError(op, ctx)
+		}
+	}
+	if partitioned {
+		return &OpError{code: DataStoreError, op: op, cause: errors.New("Partitioned from the datastore")}
+	}
+	if failed {
+		return &OpError{code: fault.code, op: op, cause: errors.New("Injected fault")}
+	}
+	return nil
+}
+
+func (cds *ChaosDataStore) PutIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (string, uint64, error) {
+	if err := cds.inject(ctx, "PutIfAbsent"); err != nil {
+		return "", 0, err
+	}
+	return cds.DataStore.PutIfAbsent(ctx, key, value, ttl)
+}
+
+func (cds *ChaosDataStore) RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if err := cds.inject(ctx, "RefreshTTL"); err != nil {
+		return err
+	}
+	return cds.DataStore.RefreshTTL(ctx, key, value, ttl)
+}
+
+func (cds *ChaosDataStore) Get(ctx context.Context, key string) (string, uint64, error) {
+	if err := cds.inject(ctx, "Get"); err != nil {
+		return "", 0, err
+	}
+	return cds.DataStore.Get(ctx, key)
+}
+
+func (cds *ChaosDataStore) Del(ctx context.Context, key string) error {
+	if err := cds.inject(ctx, "Del"); err != nil {
+		return err
+	}
+	return cds.DataStore.Del(ctx, key)
+}
+
+func (cds *ChaosDataStore) CompareAndDel(ctx context.Context, key string, prevValue string) error {
+	if err := cds.inject(ctx, "CompareAndDel"); err != nil {
+		return err
+	}
+	return cds.DataStore.CompareAndDel(ctx, key, prevValue)
+}
+
+func (cds *ChaosDataStore) List(ctx context.Context, prefix string) (map[string]KeyValue, error) {
+	if err := cds.inject(ctx, "List"); err != nil {
+		return nil, err
+	}
+	return cds.DataStore.List(ctx, prefix)
+}
+
+func (cds *ChaosDataStore) Watch(ctx context.Context, key string, l Listener) error {
+	if err := cds.inject(ctx, "Watch"); err != nil {
+		return err
+	}
+	ctx, cl := cds.listen(ctx, l)
+	if err := cds.DataStore.Watch(ctx, key, cl); err != nil {
+		cl.end()
+		return err
+	}
+	return nil
+}
+
+func (cds *ChaosDataStore) WatchPrefix(ctx context.Context, prefix string, l Listener) error {
+	if err := cds.inject(ctx, "WatchPrefix"); err != nil {
+		return err
+	}
+	ctx, cl := cds.listen(ctx, l)
+	if err := cds.DataStore.WatchPrefix(ctx, prefix, cl); err != nil {
+		cl.end()
+		return err
+	}
+	return nil
+}
+
+// listen wraps l into a chaosListener, and returns it along with the ctx of its watch on
+// the wrapped DataStore, which is cancelled once the watch ends. The chaosListener is
+// tracked for ByeWatches till then.
+func (cds *ChaosDataStore) listen(ctx context.Context, l Listener) (context.Context, *chaosListener) {
+	ctx, cancel := context.WithCancel(ctx)
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+	cl := &chaosListener{cds: cds, id: cds.nextID, l: l, cancel: cancel}
+	cds.nextID++
+	cds.listeners[cl.id] = cl
+	go func() {
+		<-ctx.Done()
+		cds.mu.Lock()
+		delete(cds.listeners, cl.id)
+		cds.mu.Unlock()
+	}()
+	return ctx, cl
+}
+
+// chaosListener drops and delays the changes seen by a watch, and hands over the rest to
+// the Listener of the watch
+type chaosListener struct {
+	cds    *ChaosDataStore
+	id     int
+	l      Listener
+	cancel context.CancelFunc
+	mu     sync.Mutex //Protects ended
+	ended  bool
+}
+
+// end ends the watch, and tells if it was running till then
+func (cl *chaosListener) end() bool {
+	cl.mu.Lock()
+	ended := cl.ended
+	cl.ended = true
+	cl.mu.Unlock()
+	cl.cancel()
+	return !ended
+}
+
+func (cl *chaosListener) Notify(change *Change) {
+	cl.cds.mu.Lock()
+	dropped := cl.cds.rand.Float64() < cl.cds.dropRate
+	delay := cl.cds.eventDelay
+	cl.cds.mu.Unlock()
+	if dropped {
+		return
+	}
+	if delay > 0 {
+		<-cl.cds.clock.After(delay)
+	}
+	cl.mu.Lock()
+	ended := cl.ended
+	cl.mu.Unlock()
+	if !ended {
+		cl.l.Notify(change)
+	}
+}
+
+func (cl *chaosListener) Bye(err error) {
+	if cl.end() {
+		cl.l.Bye(err)
+	}
+}
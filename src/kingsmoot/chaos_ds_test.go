@@ -0,0 +1,182 @@
+package kingsmoot_test
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+func newChaosDataStore(t *testing.T) *kingsmoot.ChaosDataStore {
+	ds, err := kingsmoot.NewMemoryDataStore(context.Background(), testMemoryConf(t.Name()))
+	assertNil(t, err, "Failed to create ds")
+	return kingsmoot.NewChaosDataStore(ds)
+}
+
+func assertCode(t *testing.T, err error, expected kingsmoot.ErrorCode, msg string) {
+	if err == nil || err.(kingsmoot.Error).Code() != expected {
+		t.Fatalf("%v, expected %v Got %v", msg, expected, err)
+	}
+}
+
+func TestChaosFaults(t *testing.T) {
+	cds := newChaosDataStore(t)
+	defer cds.Close()
+	cds.Fail(kingsmoot.Timeout, 1, "Get")
+	_, _, err := cds.Get(context.Background(), "testkey")
+	assertCode(t, err, kingsmoot.Timeout, "1:Get should have failed")
+	_, _, err = cds.PutIfAbsent(context.Background(), "testkey", "testvalue123", time.Second)
+	assertNil(t, err, "2:PutIfAbsent should not have failed")
+	cds.Fail(kingsmoot.CompareFailed, 1)
+	err = cds.RefreshTTL(context.Background(), "testkey", "testvalue123", time.Second)
+	assertCode(t, err, kingsmoot.CompareFailed, "3:RefreshTTL should have failed")
+	cds.Fail(kingsmoot.DataStoreError, 0)
+	assertNil(t, cds.RefreshTTL(context.Background(), "testkey", "testvalue123", time.Second), "4:RefreshTTL should not have failed")
+
+	cds.Heal()
+	cds.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	value, _, err := cds.Get(context.Background(), "testkey")
+	if err != nil || value != "testvalue123" || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("5:Get should have succeeded after 50ms, Got %v %v after %v", value, err, time.Since(start))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = cds.Get(ctx, "testkey")
+	assertCode(t, err, kingsmoot.Timeout, "6:Get should have timed out")
+
+	cds.Heal()
+	cds.Partition()
+	err = cds.Del(context.Background(), "testkey")
+	assertCode(t, err, kingsmoot.DataStoreError, "7:Del should have failed while partitioned")
+	cds.Heal()
+	assertNil(t, cds.Del(context.Background(), "testkey"), "8:Del should not have failed once healed")
+}
+
+func TestChaosSeedAndClock(t *testing.T) {
+	outcomes := func(cds *kingsmoot.ChaosDataStore) []bool {
+		cds.Fail(kingsmoot.Timeout, 0.5, "Get")
+		var failed []bool
+		for i := 0; i < 32; i++ {
+			_, _, err := cds.Get(context.Background(), "testkey")
+			failed = append(failed, err != nil && err.(kingsmoot.Error).Code() == kingsmoot.Timeout)
+		}
+		return failed
+	}
+	ds1, err := kingsmoot.NewMemoryDataStore(context.Background(), testMemoryConf(t.Name()))
+	assertNil(t, err, "1:Failed to create ds")
+	ds2, err := kingsmoot.NewMemoryDataStore(context.Background(), testMemoryConf(t.Name()))
+	assertNil(t, err, "2:Failed to create ds")
+	first, second := outcomes(kingsmoot.NewChaosDataStore(ds1, kingsmoot.ChaosSeed(7))), outcomes(kingsmoot.NewChaosDataStore(ds2, kingsmoot.ChaosSeed(7)))
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("3:Same seed should have failed the same Gets Got %v and %v", first, second)
+		}
+	}
+
+	clock := kingsmoot.NewFakeClock(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	cds := kingsmoot.NewChaosDataStore(ds1, kingsmoot.ChaosClock(clock))
+	cds.SetLatency(time.Minute)
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := cds.Get(context.Background(), "testkey")
+		done <- err
+	}()
+	for clock.Pending() == 0 {
+		<-time.After(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("4:Get should have waited for the clock Got %v", err)
+	default:
+	}
+	clock.Advance(time.Minute)
+	if err := <-done; err == nil || err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		t.Fatalf("5:Get should have gone through once the clock moved Got %v", err)
+	}
+}
+
+type recordingListener struct {
+	changeCh chan *kingsmoot.Change
+	errCh    chan error
+}
+
+func (l *recordingListener) Notify(change *kingsmoot.Change) {
+	l.changeCh <- change
+}
+
+func (l *recordingListener) Bye(err error) {
+	l.errCh <- err
+}
+
+func TestChaosWatch(t *testing.T) {
+	cds := newChaosDataStore(t)
+	defer cds.Close()
+	l := &recordingListener{changeCh: make(chan *kingsmoot.Change, 16), errCh: make(chan error, 16)}
+	assertNil(t, cds.Watch(context.Background(), "testkey", l), "1:Failed to watch")
+	cds.DropEvents(1)
+	cds.PutIfAbsent(context.Background(), "testkey", "testvalue123", time.Second)
+	select {
+	case change := <-l.changeCh:
+		t.Fatalf("2:Change should have been dropped, Got %v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cds.Heal()
+	cds.DelayEvents(100 * time.Millisecond)
+	start := time.Now()
+	cds.Del(context.Background(), "testkey")
+	select {
+	case <-l.changeCh:
+		if time.Since(start) < 100*time.Millisecond {
+			t.Fatalf("3:Change should have been delayed, Got it after %v", time.Since(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("4:Change should have been seen")
+	}
+	cds.Heal()
+	cds.ByeWatches(errors.New("Injected"))
+	select {
+	case err := <-l.errCh:
+		if err.Error() != "Injected" {
+			t.Fatalf("5:Expected injected error Got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("6:Watch should have ended")
+	}
+	cds.PutIfAbsent(context.Background(), "testkey", "testvalue123", time.Second)
+	select {
+	case change := <-l.changeCh:
+		t.Fatalf("7:Watch should have ended, Got %v", change)
+	case err := <-l.errCh:
+		t.Fatalf("8:Watch should have ended once, Got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestChaosPartition(t *testing.T) {
+	cdss := []*kingsmoot.ChaosDataStore{newChaosDataStore(t), newChaosDataStore(t)}
+	conf := testMemoryConf(t.Name())
+	c1, c2 := CreateCandidate("akem1:6379"), CreateCandidate("akem2:6379")
+	km1 := kingsmoot.NewFromDataStore(conf, cdss[0])
+	defer km1.Exit()
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	km2 := kingsmoot.NewFromDataStore(conf, cdss[1])
+	defer km2.Exit()
+	assertNil(t, km2.Join(c2.endpoint, c2), "5:Failed to join leader election")
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "6")
+
+	// The leader cut off from the datastore gives up before its key expires, and the
+	// follower takes over once it has
+	cdss[0].Partition()
+	awaitState(t, c1.roleCh, kingsmoot.NotAMember, conf.MasterDownAfter, "7")
+	select {
+	case state := <-c2.roleCh:
+		t.Fatalf("8:Follower should not have moved before the leader gave up, Got %v", state)
+	default:
+	}
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 2*conf.MasterDownAfter, "9")
+	cdss[0].Heal()
+	awaitState(t, c1.roleCh, kingsmoot.Follower, 2*conf.MasterDownAfter, "10")
+}
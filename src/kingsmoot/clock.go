@@ -0,0 +1,140 @@
+package kingsmoot
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time as seen by Kingsmoot: when to campaign again, to back off from a
+// failed watch, to give up leadership, and when keys of the MemoryDataStore expire. It is
+// set in Config for tests to run elections on simulated time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a call of Clock.AfterFunc
+type Timer interface {
+	// Stop keeps the call from happening, and tells if it had not happened yet
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (conf *Config) clock() Clock {
+	if conf.Clock != nil {
+		return conf.Clock
+	}
+	return realClock{}
+}
+
+// FakeClock is a Clock whose time only moves on Advance, firing what was due by then in
+// order of time
+type FakeClock struct {
+	mu      sync.Mutex //Protects everything below
+	now     time.Time
+	seq     uint64
+	pending []*fakeTimer
+}
+
+type fakeTimer struct {
+	c    *FakeClock
+	at   time.Time
+	seq  uint64 //Orders timers due at the same time by when they were set
+	fire func(now time.Time)
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.add(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(d, func(time.Time) { go f() })
+}
+
+func (c *FakeClock) add(d time.Duration, fire func(now time.Time)) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &fakeTimer{c: c, at: c.now.Add(d), seq: c.seq, fire: fire}
+	c.pending = append(c.pending, t)
+	sort.Sort(byDue(c.pending))
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	for i, other := range t.c.pending {
+		if other == t {
+			t.c.pending = append(t.c.pending[:i], t.c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the time forward by d. Timers due by then fire one at a time, each at the
+// time it was due, and so do those they set which are due by then.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	until := c.now.Add(d)
+	for len(c.pending) > 0 && !c.pending[0].at.After(until) {
+		t := c.pending[0]
+		c.pending = c.pending[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		now := c.now
+		c.mu.Unlock()
+		t.fire(now)
+		c.mu.Lock()
+	}
+	c.now = until
+	c.mu.Unlock()
+}
+
+// Pending returns the number of timers which have not fired yet
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+type byDue []*fakeTimer
+
+func (ts byDue) Len() int      { return len(ts) }
+func (ts byDue) Swap(i, j int) { ts[i], ts[j] = ts[j], ts[i] }
+func (ts byDue) Less(i, j int) bool {
+	if ts[i].at.Equal(ts[j].at) {
+		return ts[i].seq < ts[j].seq
+	}
+	return ts[i].at.Before(ts[j].at)
+}
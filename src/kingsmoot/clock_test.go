@@ -0,0 +1,40 @@
+package kingsmoot_test
+
+import (
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := kingsmoot.NewFakeClock(start)
+	fired := make(chan string, 16)
+	after := clock.After(2 * time.Second)
+	clock.AfterFunc(time.Second, func() { fired <- "1s" })
+	stopped := clock.AfterFunc(time.Second, func() { fired <- "stopped" })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("1:Stop should tell that the call had not happened, once")
+	}
+	clock.Advance(1500 * time.Millisecond)
+	select {
+	case f := <-fired:
+		if f != "1s" {
+			t.Fatalf("2:Expected the 1s call Got %v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("2:Call due at 1s should have happened")
+	}
+	select {
+	case now := <-after:
+		t.Fatalf("3:After 2s should not have fired at %v", now)
+	default:
+	}
+	clock.Advance(time.Second)
+	if now := <-after; !now.Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("4:After 2s should have fired at 2s Got %v", now)
+	}
+	if now := clock.Now(); !now.Equal(start.Add(2500*time.Millisecond)) || clock.Pending() != 0 {
+		t.Fatalf("5:Expected 2.5s with nothing pending Got %v with %v pending", now, clock.Pending())
+	}
+}
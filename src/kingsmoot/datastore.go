@@ -6,18 +6,38 @@ import (
 	"log"
 	"strings"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
+// DataStore operations give up once ctx is done. A Watch lasts till its ctx is done or the
+// DataStore is closed, whichever happens first.
 type DataStore interface {
-	PutIfAbsent(key string, value string, ttl time.Duration) (prevValue string, err error)
-	RefreshTTL(key string, value string, ttl time.Duration) (err error)
-	Get(key string) (value string, err error)
-	Del(key string) error
-	CompareAndDel(key string, prevValue string) error
-	Watch(key string, watch Listener) error
+	// PutIfAbsent returns the fencing token of the key, i.e. the index at which it was created,
+	// along with the value it holds if it already existed.
+	PutIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (prevValue string, token uint64, err error)
+	RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) (err error)
+	// Get returns the fencing token of the key along with its value, as PutIfAbsent does
+	Get(ctx context.Context, key string) (value string, token uint64, err error)
+	Del(ctx context.Context, key string) error
+	CompareAndDel(ctx context.Context, key string, prevValue string) error
+	Watch(ctx context.Context, key string, watch Listener) error
+	// List returns the values of the keys under prefix, along with their fencing tokens, by
+	// key, empty if there are none. prefix ends with a "/", keys under it are of the form
+	// prefix + name.
+	List(ctx context.Context, prefix string) (map[string]KeyValue, error)
+	// WatchPrefix is Watch of every key under prefix, Change.Key tells which one changed
+	WatchPrefix(ctx context.Context, prefix string, watch Listener) error
 	Close() error
 }
 
+// KeyValue is a key as listed by List
+type KeyValue struct {
+	Value string
+	// Token is the fencing token of the key, as Get returns it
+	Token uint64
+}
+
 type ChangeType int
 
 const (
@@ -37,12 +57,13 @@ func (ct ChangeType) String() string {
 
 type Change struct {
 	ChangeType ChangeType
+	Key        string
 	NewValue   string
 	PrevValue  string
 }
 
 func (c *Change) String() string {
-	return fmt.Sprintf("%v:Prev[%v]:New[%v]", c.ChangeType, c.PrevValue, c.NewValue)
+	return fmt.Sprintf("%v:%v:Prev[%v]:New[%v]", c.ChangeType, c.Key, c.PrevValue, c.NewValue)
 }
 
 type Listener interface {
@@ -50,7 +71,7 @@ type Listener interface {
 	Bye(err error)
 }
 
-type DataStoreFactory func(conf *Config) (DataStore, error)
+type DataStoreFactory func(ctx context.Context, conf *Config) (DataStore, error)
 
 var dsFactories = make(map[string]DataStoreFactory)
 
@@ -67,9 +88,15 @@ func Register(name string, factory DataStoreFactory) {
 
 func init() {
 	Register("etcdv2", NewEtcdV2DataStore)
+	Register("etcdv3", NewEtcdV3DataStore)
+	Register("memory", NewMemoryDataStore)
 }
 
 func CreateDatastore(conf *Config) (DataStore, error) {
+	return CreateDatastoreContext(context.Background(), conf)
+}
+
+func CreateDatastoreContext(ctx context.Context, conf *Config) (DataStore, error) {
 	dsFactory, ok := dsFactories[conf.DataStoreType]
 	if !ok {
 		availableDsFactories := make([]string, len(dsFactories))
@@ -78,5 +105,9 @@ func CreateDatastore(conf *Config) (DataStore, error) {
 		}
 		return nil, errors.New(fmt.Sprintf("Invalid Datastore name. Must be one of: %s", strings.Join(availableDsFactories, ", ")))
 	}
-	return dsFactory(conf)
+	ds, err := dsFactory(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return instrument(ds, conf.Name), nil
 }
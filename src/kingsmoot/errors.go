@@ -11,6 +11,7 @@ const (
 	CompareFailed
 	DataStoreError
 	Timeout
+	IllegalTransition
 )
 
 var errorCodes = []string{
@@ -19,7 +20,8 @@ var errorCodes = []string{
 	"KeyExists",
 	"CompareFailed",
 	"DataStoreError",
-	"Timeout"}
+	"Timeout",
+	"IllegalTransition"}
 
 func (e ErrorCode) String() string {
 	return errorCodes[e-1]
@@ -1,6 +1,9 @@
 package kingsmoot
 
 import (
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"errors"
@@ -9,9 +12,11 @@ import (
 )
 
 type EtcdV2DataStore struct {
-	keysClient client.KeysAPI
-	cancel     context.CancelFunc
-	ctx        context.Context
+	keysClient  client.KeysAPI
+	watchClient client.KeysAPI
+	opTimeout   time.Duration
+	cancel      context.CancelFunc
+	ctx         context.Context
 }
 
 func (ev2DS *EtcdV2DataStore) Close() error {
@@ -30,86 +35,183 @@ func (ev2DS *EtcdV2DataStore) Close() error {
 	return nil
 }
 
-func (ev2DS *EtcdV2DataStore) Watch(k string, l Listener) error {
-	c := ev2DS.keysClient
-	w := c.Watcher(k, nil)
+// opCtx bounds an operation by DsOpTimeout on top of ctx. HeaderTimeoutPerRequest alone
+// does not cover reading the body of the response.
+func (ev2DS *EtcdV2DataStore) opCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ev2DS.opTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, ev2DS.opTimeout)
+}
+
+// Watch follows the key from the etcd index at the time of the call, so that changes made
+// right after Watch returns are not missed while the watcher is being set up.
+func (ev2DS *EtcdV2DataStore) Watch(ctx context.Context, k string, l Listener) error {
+	return ev2DS.watch(ctx, "Watch", k, false, l)
+}
+
+// WatchPrefix watches prefix as a directory, recursively
+func (ev2DS *EtcdV2DataStore) WatchPrefix(ctx context.Context, prefix string, l Listener) error {
+	return ev2DS.watch(ctx, "WatchPrefix", prefix, true, l)
+}
+
+func (ev2DS *EtcdV2DataStore) watch(ctx context.Context, op string, k string, recursive bool, l Listener) error {
+	index, err := ev2DS.currentIndex(ctx, op, k)
+	if err != nil {
+		return err
+	}
+	w := ev2DS.watchClient.Watcher(k, &client.WatcherOptions{AfterIndex: index, Recursive: recursive})
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ev2DS.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 	go func(watcher client.Watcher) {
+		defer cancel()
 		for {
-			resp, err := watcher.Next(ev2DS.ctx)
+			resp, err := watcher.Next(ctx)
 			if nil != err {
-				l.Bye(&OpError{code: DataStoreError, op: "Watch", cause: err})
+				l.Bye(&OpError{code: DataStoreError, op: op, cause: err})
 				break
 			}
+			if resp.Node.Dir {
+				continue
+			}
+			key := trimKey(resp.Node.Key)
 			switch resp.Action {
 			case "create":
-				l.Notify(&Change{ChangeType: Created, NewValue: resp.Node.Value})
+				l.Notify(&Change{ChangeType: Created, Key: key, NewValue: resp.Node.Value})
 			case "compareAndSwap", "update", "set":
-				l.Notify(&Change{ChangeType: Updated, NewValue: resp.Node.Value, PrevValue: resp.PrevNode.Value})
+				l.Notify(&Change{ChangeType: Updated, Key: key, NewValue: resp.Node.Value, PrevValue: resp.PrevNode.Value})
 			case "compareAndDelete", "delete", "expire":
-				l.Notify(&Change{ChangeType: Deleted, PrevValue: resp.PrevNode.Value})
+				l.Notify(&Change{ChangeType: Deleted, Key: key, PrevValue: resp.PrevNode.Value})
 			}
 		}
 	}(w)
 	return nil
 }
 
-func (ev2DS *EtcdV2DataStore) Del(key string) error {
+// trimKey strips the leading "/" etcd v2 adds to keys given without one
+func trimKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+func (ev2DS *EtcdV2DataStore) currentIndex(ctx context.Context, op string, key string) (uint64, error) {
+	ctx, cancel := ev2DS.opCtx(ctx)
+	defer cancel()
+	resp, err := ev2DS.keysClient.Get(ctx, key, &client.GetOptions{})
+	if err == nil {
+		return resp.Index, nil
+	}
+	if cerr, ok := err.(client.Error); ok && cerr.Code == client.ErrorCodeKeyNotFound {
+		return cerr.Index, nil
+	}
+	return 0, adapt(err, op)
+}
+
+// List gets prefix as a directory, recursively
+func (ev2DS *EtcdV2DataStore) List(ctx context.Context, prefix string) (map[string]KeyValue, error) {
+	ctx, cancel := ev2DS.opCtx(ctx)
+	defer cancel()
+	values := make(map[string]KeyValue)
+	resp, err := ev2DS.keysClient.Get(ctx, prefix, &client.GetOptions{Recursive: true})
+	if err != nil {
+		if cerr, ok := err.(client.Error); ok && cerr.Code == client.ErrorCodeKeyNotFound {
+			return values, nil
+		}
+		return nil, adapt(err, "List")
+	}
+	var collect func(node *client.Node)
+	collect = func(node *client.Node) {
+		if !node.Dir {
+			values[trimKey(node.Key)] = KeyValue{Value: node.Value, Token: node.CreatedIndex}
+		}
+		for _, child := range node.Nodes {
+			collect(child)
+		}
+	}
+	collect(resp.Node)
+	return values, nil
+}
+
+func (ev2DS *EtcdV2DataStore) Del(ctx context.Context, key string) error {
 	c := ev2DS.keysClient
-	_, err := c.Delete(context.TODO(), key, nil)
+	ctx, cancel := ev2DS.opCtx(ctx)
+	defer cancel()
+	_, err := c.Delete(ctx, key, nil)
 	if err != nil {
 		return adapt(err, "Del")
 	}
 	return nil
 }
 
-func (ev2DS *EtcdV2DataStore) CompareAndDel(key string, value string) error {
+func (ev2DS *EtcdV2DataStore) CompareAndDel(ctx context.Context, key string, value string) error {
 	c := ev2DS.keysClient
-	_, err := c.Delete(context.TODO(), key, &client.DeleteOptions{PrevValue: value})
+	ctx, cancel := ev2DS.opCtx(ctx)
+	defer cancel()
+	_, err := c.Delete(ctx, key, &client.DeleteOptions{PrevValue: value})
 	if err != nil {
 		return adapt(err, "CompareAndDel")
 	}
 	return nil
 }
 
-func (ev2DS *EtcdV2DataStore) Get(key string) (string, error) {
+func (ev2DS *EtcdV2DataStore) get(ctx context.Context, op string, key string) (*client.Node, error) {
 	c := ev2DS.keysClient
-	resp, err := c.Get(context.TODO(), key, &client.GetOptions{})
+	ctx, cancel := ev2DS.opCtx(ctx)
+	defer cancel()
+	resp, err := c.Get(ctx, key, &client.GetOptions{})
 	if nil != err {
-		return "", adapt(err, "Get")
+		return nil, adapt(err, op)
 	}
-	return resp.Node.Value, nil
+	return resp.Node, nil
 }
 
-func (ev2DS *EtcdV2DataStore) RefreshTTL(key string, value string, ttl time.Duration) error {
+func (ev2DS *EtcdV2DataStore) Get(ctx context.Context, key string) (string, uint64, error) {
+	node, err := ev2DS.get(ctx, "Get", key)
+	if nil != err {
+		return "", 0, err
+	}
+	return node.Value, node.CreatedIndex, nil
+}
+
+func (ev2DS *EtcdV2DataStore) RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
 	c := ev2DS.keysClient
-	_, err := c.Set(context.TODO(), key, "", &client.SetOptions{TTL: ttl, PrevValue: value, Refresh: true})
+	ctx, cancel := ev2DS.opCtx(ctx)
+	defer cancel()
+	_, err := c.Set(ctx, key, "", &client.SetOptions{TTL: ttl, PrevValue: value, Refresh: true})
 	if nil != err {
 		return adapt(err, "RefreshTTL")
 	}
 	return nil
 }
 
-func (ev2DS *EtcdV2DataStore) PutIfAbsent(key string, value string, ttl time.Duration) (prevValue string, err error) {
+func (ev2DS *EtcdV2DataStore) PutIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (prevValue string, token uint64, err error) {
 	c := ev2DS.keysClient
 	for {
-		_, err = c.Set(context.TODO(), key, value, &client.SetOptions{TTL: ttl, PrevExist: client.PrevNoExist})
+		setCtx, cancel := ev2DS.opCtx(ctx)
+		resp, err := c.Set(setCtx, key, value, &client.SetOptions{TTL: ttl, PrevExist: client.PrevNoExist})
+		cancel()
 		if err != nil {
 			myerr := adapt(err, "PutIfAbsent")
 			switch myerr.Code() {
 			case KeyExists:
-				prevValue, err = ev2DS.Get(key)
+				node, err := ev2DS.get(ctx, "PutIfAbsent", key)
 				if err != nil {
 					if err.(Error).Code() != KeyNotFound {
-						return "", err
+						return "", 0, err
 					}
 				} else {
-					return prevValue, &OpError{code: KeyExists, op: "PutIfAbsent", cause: err}
+					return node.Value, node.CreatedIndex, myerr
 				}
 			default:
-				return "", myerr
+				return "", 0, myerr
 			}
 		} else {
-			return "", nil
+			return "", resp.Node.CreatedIndex, nil
 		}
 	}
 }
@@ -124,6 +226,9 @@ func (ev2DS *EtcdV2DataStore) autoSync(c client.Client) {
 }
 
 func adapt(err error, op string) Error {
+	if err == context.DeadlineExceeded {
+		return &OpError{code: Timeout, op: op, cause: err}
+	}
 	cerr, ok := err.(client.Error)
 	if !ok {
 		return &OpError{code: DataStoreError, op: op, cause: err}
@@ -162,14 +267,41 @@ func NewEtcdV2Client(conf *Config) (client.Client, client.KeysAPI, error) {
 	return cl, client.NewKeysAPI(cl), nil
 }
 
-func NewEtcdV2DataStore(conf *Config) (DataStore, error) {
+func newKeysAPI(c *client.Config) (client.KeysAPI, error) {
+	cl, err := client.New(*c)
+	if err != nil {
+		return nil, &OpError{code: DataStoreError, op: "ConnectToEtcd", cause: err}
+	}
+	return client.NewKeysAPI(cl), nil
+}
+
+// watchTransport never reuses connections. A watch is a long poll whose response headers
+// come right away and body only on a change, so a connection of a watch given up on must
+// not be handed to another request.
+var watchTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	Dial: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).Dial,
+	TLSHandshakeTimeout: 10 * time.Second,
+	DisableKeepAlives:   true,
+}
+
+func NewEtcdV2DataStore(ctx context.Context, conf *Config) (DataStore, error) {
 	client, keysAPI, err := NewEtcdV2Client(conf)
 	if err != nil {
 		return nil, err
 	}
+	c, _ := NewV2Config(conf)
+	c.Transport = watchTransport
+	watchClient, err := newKeysAPI(c)
+	if err != nil {
+		return nil, err
+	}
 
-	ds := &EtcdV2DataStore{keysClient: keysAPI}
-	if _, err := ds.Get("ping"); err != nil {
+	ds := &EtcdV2DataStore{keysClient: keysAPI, watchClient: watchClient, opTimeout: conf.DsOpTimeout}
+	if _, _, err := ds.Get(ctx, "ping"); err != nil {
 		if err.(Error).Code() != KeyNotFound {
 			ds.Close()
 			return nil, err
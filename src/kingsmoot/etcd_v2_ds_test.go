@@ -2,6 +2,7 @@ package kingsmoot_test
 
 import (
 	"errors"
+	"golang.org/x/net/context"
 	"io/ioutil"
 	"kingsmoot"
 	"os"
@@ -32,13 +33,13 @@ func init() {
 }
 
 func newEtcdV2DataStore(t *testing.T) kingsmoot.DataStore {
-	ds, err := kingsmoot.NewEtcdV2DataStore(testV2Conf())
+	ds, err := kingsmoot.NewEtcdV2DataStore(context.Background(), testV2Conf())
 	assertNil(t, err, "Failed to create ds")
 	return ds
 }
 
 func putIfAbsent(ds kingsmoot.DataStore, t *testing.T, k string, v string, ttl time.Duration) (prevValue string) {
-	prevValue, err := ds.PutIfAbsent(k, v, ttl)
+	prevValue, _, err := ds.PutIfAbsent(context.Background(), k, v, ttl)
 	if nil != err {
 		switch err.(kingsmoot.Error).Code() {
 		case kingsmoot.KeyExists:
@@ -53,7 +54,7 @@ func putIfAbsent(ds kingsmoot.DataStore, t *testing.T, k string, v string, ttl t
 func TestPutIfAbsent(t *testing.T) {
 	ds := newEtcdV2DataStore(t)
 	defer ds.Close()
-	defer ds.Del("testkey")
+	defer ds.Del(context.Background(), "testkey")
 	value := putIfAbsent(ds, t, "testkey", "testvalue123", 10*time.Second)
 	if value != "" {
 		t.Fatalf("Not exptecting any value, got %v", value)
@@ -72,7 +73,7 @@ func TestPutIfAbsent(t *testing.T) {
 func TestParallelPutIfAbsent(t *testing.T) {
 	ds := newEtcdV2DataStore(t)
 	defer ds.Close()
-	defer ds.Del("testkey")
+	defer ds.Del(context.Background(), "testkey")
 	values := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
 	done := make(chan bool, 10)
 	var errCount int32
@@ -100,21 +101,21 @@ func TestParallelPutIfAbsent(t *testing.T) {
 func TestRefreshTTL(t *testing.T) {
 	ds := newEtcdV2DataStore(t)
 	defer ds.Close()
-	defer ds.Del("testkey")
+	defer ds.Del(context.Background(), "testkey")
 	putIfAbsent(ds, t, "testkey", "testvalue123", 10*time.Second)
-	err := ds.RefreshTTL("testkey", "testvalue456", 10*time.Second)
+	err := ds.RefreshTTL(context.Background(), "testkey", "testvalue456", 10*time.Second)
 	assertNotNil(t, err, "RefreshTTL should have failed as value given is different")
 	if err.(kingsmoot.Error).Code() != kingsmoot.CompareFailed {
 		t.Fatal("RefreshTTL should have failed as due to CompareFailed", err)
 	}
 	time.Sleep(5 * time.Second)
-	err = ds.RefreshTTL("testkey", "testvalue123", 5*time.Second)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 5*time.Second)
 	assertNil(t, err, "Failed to RefreshTTL")
 	time.Sleep(3 * time.Second)
-	err = ds.RefreshTTL("testkey", "testvalue123", 5*time.Second)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 5*time.Second)
 	assertNil(t, err, "Failed to RefreshTTL")
 	time.Sleep(7 * time.Second)
-	err = ds.RefreshTTL("testkey", "testvalue123", 10*time.Second)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 10*time.Second)
 	assertNotNil(t, err, "Failed to RefreshTTL")
 	if err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
 		t.Fatal("RefreshTTL should have failed as due to Key getting expired", err)
@@ -124,29 +125,29 @@ func TestRefreshTTL(t *testing.T) {
 func TestDelIfPresent(t *testing.T) {
 	ds := newEtcdV2DataStore(t)
 	defer ds.Close()
-	defer ds.Del("testkey")
+	defer ds.Del(context.Background(), "testkey")
 	putIfAbsent(ds, t, "testkey", "testvalue123", 5*time.Second)
-	err := ds.CompareAndDel("test123", "abcd")
+	err := ds.CompareAndDel(context.Background(), "test123", "abcd")
 	assertNotNil(t, err, "Should have failed for a non existent key")
 	if err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
 		t.Fatal("Failed with wrong error code, should  have been KeyNotFound", err)
 	}
-	err = ds.CompareAndDel("testkey", "abcd")
+	err = ds.CompareAndDel(context.Background(), "testkey", "abcd")
 	assertNotNil(t, err, "Should have failed as value is not same")
 	if err.(kingsmoot.Error).Code() != kingsmoot.CompareFailed {
 		t.Fatal("Failed with wrong error code, should  have been CompareFailed", err)
 	}
-	err = ds.CompareAndDel("testkey", "testvalue123")
+	err = ds.CompareAndDel(context.Background(), "testkey", "testvalue123")
 	assertNil(t, err, "DelIfPresent should have been successful")
 }
 
 func TestWatch(t *testing.T) {
 	ds := newEtcdV2DataStore(t)
 	defer ds.Close()
-	defer ds.Del("testkey")
+	defer ds.Del(context.Background(), "testkey")
 	putIfAbsent(ds, t, "testkey", "testvalue123", 5*time.Second)
 	l := newListener()
-	err := ds.Watch("testkey", l)
+	err := ds.Watch(context.Background(), "testkey", l)
 	assertNil(t, err, "Error while setting the Watch")
 	c, err := whatChanged(l.changeCh, 6*time.Second)
 	assertNil(t, err, "1:Should have got change notification within 6 seconds")
@@ -171,11 +172,11 @@ func TestWatch(t *testing.T) {
 	if c.NewValue != "testvalue456" {
 		t.Fatalf("Expected %v Got %v", "testvalue456", c.NewValue)
 	}
-	err = ds.RefreshTTL("testkey", "testvalue456", 5*time.Second)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue456", 5*time.Second)
 	assertNil(t, err, "3:Should have refreshed ttl")
 	c, err = whatChanged(l.changeCh, 3*time.Second)
 	assertNotNil(t, err, "4:Should not have got change notification for ttl refresh")
-	err = ds.CompareAndDel("testkey", "testvalue456")
+	err = ds.CompareAndDel(context.Background(), "testkey", "testvalue456")
 	assertNil(t, err, "5:Should have deleted the key")
 	c, err = whatChanged(l.changeCh, 2*time.Second)
 	assertNil(t, err, "6:Should have got change notification within 2 seconds")
@@ -199,3 +200,54 @@ func whatChanged(c chan *kingsmoot.Change, timeout time.Duration) (*kingsmoot.Ch
 		return nil, errors.New("Timeout")
 	}
 }
+
+// listAndWatchPrefix checks List and WatchPrefix of ds against keys under testdir/
+func listAndWatchPrefix(t *testing.T, ds kingsmoot.DataStore) {
+	defer ds.Del(context.Background(), "testdir/a")
+	defer ds.Del(context.Background(), "testdir/b")
+	defer ds.Del(context.Background(), "testdirx")
+	values, err := ds.List(context.Background(), "testdir/")
+	assertNil(t, err, "1:Failed to list empty prefix")
+	if len(values) != 0 {
+		t.Fatalf("Expected no keys Got %v", values)
+	}
+	l := newListener()
+	err = ds.WatchPrefix(context.Background(), "testdir/", l)
+	assertNil(t, err, "2:Error while setting the Watch")
+	putIfAbsent(ds, t, "testdir/a", "testvalue123", 5*time.Second)
+	c, err := whatChanged(l.changeCh, 2*time.Second)
+	assertNil(t, err, "3:Should have got create notification")
+	if c.ChangeType != kingsmoot.Created || c.Key != "testdir/a" || c.NewValue != "testvalue123" {
+		t.Fatalf("Expected %v of testdir/a Got %v", kingsmoot.Created, c)
+	}
+	putIfAbsent(ds, t, "testdirx", "testvalue000", 5*time.Second)
+	putIfAbsent(ds, t, "testdir/b", "testvalue456", 5*time.Second)
+	c, err = whatChanged(l.changeCh, 2*time.Second)
+	assertNil(t, err, "4:Should have got create notification")
+	if c.ChangeType != kingsmoot.Created || c.Key != "testdir/b" {
+		t.Fatalf("Expected %v of testdir/b Got %v", kingsmoot.Created, c)
+	}
+	values, err = ds.List(context.Background(), "testdir/")
+	assertNil(t, err, "5:Failed to list")
+	if len(values) != 2 || values["testdir/a"].Value != "testvalue123" || values["testdir/b"].Value != "testvalue456" {
+		t.Fatalf("Expected testdir/a and testdir/b Got %v", values)
+	}
+	_, token, err := ds.Get(context.Background(), "testdir/b")
+	assertNil(t, err, "5:Failed to get")
+	if values["testdir/b"].Token != token || values["testdir/a"].Token >= token {
+		t.Fatalf("Expected testdir/a and testdir/b Got %v", values)
+	}
+	err = ds.CompareAndDel(context.Background(), "testdir/a", "testvalue123")
+	assertNil(t, err, "6:Should have deleted the key")
+	c, err = whatChanged(l.changeCh, 2*time.Second)
+	assertNil(t, err, "7:Should have got delete notification")
+	if c.ChangeType != kingsmoot.Deleted || c.Key != "testdir/a" || c.PrevValue != "testvalue123" {
+		t.Fatalf("Expected %v of testdir/a Got %v", kingsmoot.Deleted, c)
+	}
+}
+
+func TestListAndWatchPrefix(t *testing.T) {
+	ds := newEtcdV2DataStore(t)
+	defer ds.Close()
+	listAndWatchPrefix(t, ds)
+}
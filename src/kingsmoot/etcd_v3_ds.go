@@ -0,0 +1,432 @@
+package kingsmoot
+
+import (
+	"sync"
+	"time"
+
+	"errors"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
+)
+
+type EtcdV3DataStore struct {
+	client    *clientv3.Client
+	opTimeout time.Duration
+	cancel    context.CancelFunc
+	ctx       context.Context
+	mu        sync.Mutex             //Protects leases
+	leases    map[int64]*sharedLease //By TTL in seconds, nil unless leases are shared
+}
+
+// sharedLease is a lease every key written with its TTL is attached to. Refreshes of those
+// keys are batched: those asking while a keep alive is in flight share the next one.
+type sharedLease struct {
+	id       clientv3.LeaseID
+	mu       sync.Mutex //Protects inflight and next
+	inflight *keepAlive
+	next     *keepAlive
+}
+
+type keepAlive struct {
+	done chan struct{} //Closed once err is set
+	err  error
+}
+
+// shareLeases attaches the keys written from now on to one lease per TTL, kept alive
+// once for all of them
+func (ev3DS *EtcdV3DataStore) shareLeases() {
+	ev3DS.mu.Lock()
+	defer ev3DS.mu.Unlock()
+	if ev3DS.leases == nil {
+		ev3DS.leases = make(map[int64]*sharedLease)
+	}
+}
+
+// Close revokes the shared leases, deleting the keys still attached to them
+func (ev3DS *EtcdV3DataStore) Close() error {
+	if nil == ev3DS.cancel {
+		return nil
+	}
+	ev3DS.mu.Lock()
+	leases := ev3DS.leases
+	ev3DS.leases = nil
+	ev3DS.mu.Unlock()
+	for _, sl := range leases {
+		ev3DS.revoke(int64(sl.id))
+	}
+	ev3DS.cancel()
+	if err := ev3DS.client.Close(); err != nil && err != context.Canceled {
+		return &OpError{op: "Close", cause: err, code: DataStoreError}
+	}
+	return nil
+}
+
+// opCtx bounds an operation by DsOpTimeout on top of ctx
+func (ev3DS *EtcdV3DataStore) opCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ev3DS.opTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, ev3DS.opTimeout)
+}
+
+// Watch follows the key on the v3 watch stream, from the revision of the datastore when it
+// is called. When the stream breaks (lost leader, compaction) it is re-established from the
+// last revision seen, so no change is lost. The Listener gets Bye only once ctx is done or
+// the DataStore is closed.
+func (ev3DS *EtcdV3DataStore) Watch(ctx context.Context, k string, l Listener) error {
+	return ev3DS.watch(ctx, "Watch", k, l)
+}
+
+func (ev3DS *EtcdV3DataStore) WatchPrefix(ctx context.Context, prefix string, l Listener) error {
+	return ev3DS.watch(ctx, "WatchPrefix", prefix, l, clientv3.WithPrefix())
+}
+
+func (ev3DS *EtcdV3DataStore) watch(ctx context.Context, op string, k string, l Listener, extra ...clientv3.OpOption) error {
+	// Changes made once Watch returns must be seen, though the stream is set up later on
+	getCtx, getCancel := ev3DS.opCtx(ctx)
+	resp, err := ev3DS.client.Get(getCtx, k, append([]clientv3.OpOption{clientv3.WithCountOnly()}, extra...)...)
+	getCancel()
+	if err != nil {
+		return adaptV3(err, op)
+	}
+	rev := resp.Header.Revision
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ev3DS.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		defer cancel()
+		for {
+			opts := append([]clientv3.OpOption{clientv3.WithPrevKV(), clientv3.WithRev(rev + 1)}, extra...)
+			wch := ev3DS.client.Watch(clientv3.WithRequireLeader(ctx), k, opts...)
+			for resp := range wch {
+				if resp.CompactRevision != 0 {
+					rev = resp.CompactRevision - 1
+					break
+				}
+				if resp.Err() != nil {
+					break
+				}
+				for _, ev := range resp.Events {
+					rev = ev.Kv.ModRevision
+					l.Notify(toChange(ev))
+				}
+			}
+			select {
+			case <-ctx.Done():
+				l.Bye(&OpError{code: DataStoreError, op: op, cause: ctx.Err()})
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	return nil
+}
+
+func toChange(ev *clientv3.Event) *Change {
+	var prevValue string
+	if ev.PrevKv != nil {
+		prevValue = string(ev.PrevKv.Value)
+	}
+	key := string(ev.Kv.Key)
+	switch {
+	case ev.Type == mvccpb.DELETE:
+		return &Change{ChangeType: Deleted, Key: key, PrevValue: prevValue}
+	case ev.IsCreate():
+		return &Change{ChangeType: Created, Key: key, NewValue: string(ev.Kv.Value)}
+	default:
+		return &Change{ChangeType: Updated, Key: key, NewValue: string(ev.Kv.Value), PrevValue: prevValue}
+	}
+}
+
+func (ev3DS *EtcdV3DataStore) Del(ctx context.Context, key string) error {
+	ctx, cancel := ev3DS.opCtx(ctx)
+	defer cancel()
+	resp, err := ev3DS.client.Delete(ctx, key, clientv3.WithPrevKV())
+	if err != nil {
+		return adaptV3(err, "Del")
+	}
+	if resp.Deleted == 0 {
+		return &OpError{code: KeyNotFound, op: "Del", cause: errors.New("Key not found")}
+	}
+	ev3DS.revoke(resp.PrevKvs[0].Lease)
+	return nil
+}
+
+func (ev3DS *EtcdV3DataStore) CompareAndDel(ctx context.Context, key string, value string) error {
+	ctx, cancel := ev3DS.opCtx(ctx)
+	defer cancel()
+	resp, err := ev3DS.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", value)).
+		Then(clientv3.OpDelete(key, clientv3.WithPrevKV())).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return adaptV3(err, "CompareAndDel")
+	}
+	if !resp.Succeeded {
+		if len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+			return &OpError{code: KeyNotFound, op: "CompareAndDel", cause: errors.New("Key not found")}
+		}
+		return &OpError{code: CompareFailed, op: "CompareAndDel", cause: errors.New("Value does not match")}
+	}
+	if prevKvs := resp.Responses[0].GetResponseDeleteRange().PrevKvs; len(prevKvs) != 0 {
+		ev3DS.revoke(prevKvs[0].Lease)
+	}
+	return nil
+}
+
+func (ev3DS *EtcdV3DataStore) List(ctx context.Context, prefix string) (map[string]KeyValue, error) {
+	ctx, cancel := ev3DS.opCtx(ctx)
+	defer cancel()
+	resp, err := ev3DS.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, adaptV3(err, "List")
+	}
+	values := make(map[string]KeyValue, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		values[string(kv.Key)] = KeyValue{Value: string(kv.Value), Token: uint64(kv.CreateRevision)}
+	}
+	return values, nil
+}
+
+func (ev3DS *EtcdV3DataStore) get(ctx context.Context, op string, key string) (*mvccpb.KeyValue, error) {
+	ctx, cancel := ev3DS.opCtx(ctx)
+	defer cancel()
+	resp, err := ev3DS.client.Get(ctx, key)
+	if err != nil {
+		return nil, adaptV3(err, op)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, &OpError{code: KeyNotFound, op: op, cause: errors.New("Key not found")}
+	}
+	return resp.Kvs[0], nil
+}
+
+func (ev3DS *EtcdV3DataStore) Get(ctx context.Context, key string) (string, uint64, error) {
+	kv, err := ev3DS.get(ctx, "Get", key)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(kv.Value), uint64(kv.CreateRevision), nil
+}
+
+// RefreshTTL keeps alive the lease the key was written with. The lease keeps the TTL it
+// was granted with by PutIfAbsent, so ttl is not used.
+func (ev3DS *EtcdV3DataStore) RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	kv, err := ev3DS.get(ctx, "RefreshTTL", key)
+	if err != nil {
+		return err
+	}
+	if string(kv.Value) != value {
+		return &OpError{code: CompareFailed, op: "RefreshTTL", cause: errors.New("Value does not match")}
+	}
+	if sl := ev3DS.sharedLease(kv.Lease); sl != nil {
+		return ev3DS.keepAlive(ctx, sl)
+	}
+	ctx, cancel := ev3DS.opCtx(ctx)
+	defer cancel()
+	if _, err := ev3DS.client.KeepAliveOnce(ctx, clientv3.LeaseID(kv.Lease)); err != nil {
+		return adaptV3(err, "RefreshTTL")
+	}
+	return nil
+}
+
+// sharedLease returns the shared lease with the given ID, nil if it is not one
+func (ev3DS *EtcdV3DataStore) sharedLease(id int64) *sharedLease {
+	ev3DS.mu.Lock()
+	defer ev3DS.mu.Unlock()
+	for _, sl := range ev3DS.leases {
+		if int64(sl.id) == id {
+			return sl
+		}
+	}
+	return nil
+}
+
+// keepAlive waits for a keep alive of sl sent after it was called. One is sent right away
+// unless one is in flight, in which case the next one is sent once it is back.
+func (ev3DS *EtcdV3DataStore) keepAlive(ctx context.Context, sl *sharedLease) error {
+	sl.mu.Lock()
+	if sl.next == nil {
+		sl.next = &keepAlive{done: make(chan struct{})}
+	}
+	ka := sl.next
+	if sl.inflight == nil {
+		ev3DS.sendKeepAlive(sl)
+	}
+	sl.mu.Unlock()
+	select {
+	case <-ka.done:
+		if ka.err != nil {
+			return adaptV3(ka.err, "RefreshTTL")
+		}
+		return nil
+	case <-ctx.Done():
+		return ctxError("RefreshTTL", ctx)
+	}
+}
+
+// sendKeepAlive sends the next keep alive of sl, must be called with sl.mu held
+func (ev3DS *EtcdV3DataStore) sendKeepAlive(sl *sharedLease) {
+	ka := sl.next
+	sl.inflight, sl.next = ka, nil
+	go func() {
+		ctx, cancel := ev3DS.opCtx(ev3DS.ctx)
+		_, err := ev3DS.client.KeepAliveOnce(ctx, sl.id)
+		cancel()
+		if err == rpctypes.ErrLeaseNotFound {
+			ev3DS.forgetLease(sl)
+		}
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		ka.err = err
+		close(ka.done)
+		sl.inflight = nil
+		if sl.next != nil {
+			ev3DS.sendKeepAlive(sl)
+		}
+	}()
+}
+
+// lease returns the shared lease for ttl, granting it if there is none yet, or 0 if
+// leases are not shared
+func (ev3DS *EtcdV3DataStore) lease(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, error) {
+	ev3DS.mu.Lock()
+	defer ev3DS.mu.Unlock()
+	if ev3DS.leases == nil {
+		return 0, nil
+	}
+	if sl, ok := ev3DS.leases[ttlSeconds(ttl)]; ok {
+		return sl.id, nil
+	}
+	resp, err := ev3DS.client.Grant(ctx, ttlSeconds(ttl))
+	if err != nil {
+		return 0, err
+	}
+	ev3DS.leases[ttlSeconds(ttl)] = &sharedLease{id: resp.ID}
+	return resp.ID, nil
+}
+
+// forgetLease lets go of a shared lease which expired, the next key written with its TTL
+// is attached to a new one
+func (ev3DS *EtcdV3DataStore) forgetLease(sl *sharedLease) {
+	ev3DS.mu.Lock()
+	defer ev3DS.mu.Unlock()
+	for ttl, other := range ev3DS.leases {
+		if other == sl {
+			delete(ev3DS.leases, ttl)
+		}
+	}
+}
+
+func (ev3DS *EtcdV3DataStore) PutIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (prevValue string, token uint64, err error) {
+	ctx, cancel := ev3DS.opCtx(ctx)
+	defer cancel()
+	shared, err := ev3DS.lease(ctx, ttl)
+	if err != nil {
+		return "", 0, adaptV3(err, "PutIfAbsent")
+	}
+	lease := shared
+	if shared == 0 {
+		resp, err := ev3DS.client.Grant(ctx, ttlSeconds(ttl))
+		if err != nil {
+			return "", 0, adaptV3(err, "PutIfAbsent")
+		}
+		lease = resp.ID
+	}
+	resp, err := ev3DS.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, value, clientv3.WithLease(lease))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err == rpctypes.ErrLeaseNotFound && shared != 0 {
+		// The shared lease expired with no key refreshed for its TTL, write with a new one
+		if sl := ev3DS.sharedLease(int64(shared)); sl != nil {
+			ev3DS.forgetLease(sl)
+		}
+		return ev3DS.PutIfAbsent(ctx, key, value, ttl)
+	}
+	if err != nil {
+		ev3DS.revoke(int64(lease))
+		return "", 0, adaptV3(err, "PutIfAbsent")
+	}
+	if resp.Succeeded {
+		// The shared lease may be close to expiring, the key gets its full TTL only from a
+		// keep alive sent after it was attached
+		if sl := ev3DS.sharedLease(int64(shared)); shared != 0 && sl != nil {
+			if err := ev3DS.keepAlive(ctx, sl); err != nil {
+				return "", 0, err
+			}
+		}
+		return "", uint64(resp.Header.Revision), nil
+	}
+	ev3DS.revoke(int64(lease))
+	kv := resp.Responses[0].GetResponseRange().Kvs[0]
+	return string(kv.Value), uint64(kv.CreateRevision), &OpError{code: KeyExists, op: "PutIfAbsent", cause: errors.New("Key already exists")}
+}
+
+// revoke is best effort, the lease expires on its own anyway. Shared leases are only
+// revoked on Close.
+func (ev3DS *EtcdV3DataStore) revoke(lease int64) {
+	if lease == 0 || ev3DS.sharedLease(lease) != nil {
+		return
+	}
+	ctx, cancel := ev3DS.opCtx(ev3DS.ctx)
+	defer cancel()
+	ev3DS.client.Revoke(ctx, clientv3.LeaseID(lease))
+}
+
+func ttlSeconds(ttl time.Duration) int64 {
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if secs < 1 {
+		return 1
+	}
+	return secs
+}
+
+func adaptV3(err error, op string) Error {
+	switch err {
+	case context.DeadlineExceeded:
+		return &OpError{code: Timeout, op: op, cause: err}
+	case rpctypes.ErrLeaseNotFound:
+		return &OpError{code: KeyNotFound, op: op, cause: err}
+	default:
+		return &OpError{code: DataStoreError, op: op, cause: err}
+	}
+}
+
+func NewV3Config(conf *Config) (c *clientv3.Config, err error) {
+	addresses := conf.Addresses
+	if len(addresses) == 0 {
+		err = &InvalidArgumentError{Name: "addresses", Value: "", Expected: "Command separated http://host:port of seed servers"}
+		return nil, err
+	}
+	return &clientv3.Config{Endpoints: addresses, DialTimeout: conf.DsOpTimeout}, nil
+}
+
+func NewEtcdV3DataStore(ctx context.Context, conf *Config) (DataStore, error) {
+	c, err := NewV3Config(conf)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := clientv3.New(*c)
+	if err != nil {
+		return nil, &OpError{code: DataStoreError, op: "ConnectToEtcd", cause: err}
+	}
+	ds := &EtcdV3DataStore{client: cl, opTimeout: conf.DsOpTimeout}
+	ds.ctx, ds.cancel = context.WithCancel(context.Background())
+	if _, _, err := ds.Get(ctx, "ping"); err != nil {
+		if err.(Error).Code() != KeyNotFound {
+			ds.Close()
+			return nil, err
+		}
+	}
+	return ds, nil
+}
@@ -0,0 +1,326 @@
+package kingsmoot_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"kingsmoot"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+	"golang.org/x/net/context"
+)
+
+const v3ClientURL = "http://localhost:2389"
+
+func startEmbeddedEtcd(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "kingsmoot-etcdv3")
+	assertNil(t, err, "Failed to create data dir")
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	clientURL, _ := url.Parse(v3ClientURL)
+	peerURL, _ := url.Parse("http://localhost:2390")
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal("Failed to start embedded etcd", err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal("Embedded etcd took too long to start")
+	}
+	return func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testV3Conf() *kingsmoot.Config {
+	return &kingsmoot.Config{
+		Name:            "akem",
+		DataStoreType:   "etcdv3",
+		Addresses:       []string{v3ClientURL},
+		DsOpTimeout:     500 * time.Millisecond,
+		MasterDownAfter: 2 * time.Second}
+}
+
+func newEtcdV3DataStore(t *testing.T) kingsmoot.DataStore {
+	ds, err := kingsmoot.CreateDatastore(testV3Conf())
+	assertNil(t, err, "Failed to create ds")
+	return ds
+}
+
+func TestV3PutIfAbsent(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	ds := newEtcdV3DataStore(t)
+	defer ds.Close()
+	value := putIfAbsent(ds, t, "testkey", "testvalue123", 2*time.Second)
+	if value != "" {
+		t.Fatalf("Not exptecting any value, got %v", value)
+	}
+	value = putIfAbsent(ds, t, "testkey", "testvalue456", 2*time.Second)
+	if value != "testvalue123" {
+		t.Fatalf("Expected %v, got %v", "testvalue123", value)
+	}
+	time.Sleep(4 * time.Second)
+	value = putIfAbsent(ds, t, "testkey", "testvalue456", 2*time.Second)
+	if value != "" {
+		t.Fatalf("Not exptecting any value, got %v", value)
+	}
+}
+
+func TestV3ParallelPutIfAbsent(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	ds := newEtcdV3DataStore(t)
+	defer ds.Close()
+	values := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	done := make(chan bool, 10)
+	var errCount int32
+	for _, value := range values {
+		go func(c chan bool, v string) {
+			defer func() {
+				c <- true
+			}()
+			retValue := putIfAbsent(ds, t, "testkey", v, 10*time.Second)
+			if retValue != "" {
+				atomic.AddInt32(&errCount, 1)
+			}
+		}(done, value)
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+	if atomic.LoadInt32(&errCount) != 9 {
+		t.Fatalf("Among 10 tring to put, only one should have successed, but looks like %v have succeeded", 10-errCount)
+	}
+}
+
+func TestV3RefreshTTL(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	ds := newEtcdV3DataStore(t)
+	defer ds.Close()
+	putIfAbsent(ds, t, "testkey", "testvalue123", 3*time.Second)
+	err := ds.RefreshTTL(context.Background(), "testkey", "testvalue456", 3*time.Second)
+	assertNotNil(t, err, "RefreshTTL should have failed as value given is different")
+	if err.(kingsmoot.Error).Code() != kingsmoot.CompareFailed {
+		t.Fatal("RefreshTTL should have failed as due to CompareFailed", err)
+	}
+	time.Sleep(2 * time.Second)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 3*time.Second)
+	assertNil(t, err, "Failed to RefreshTTL")
+	time.Sleep(2 * time.Second)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 3*time.Second)
+	assertNil(t, err, "Failed to RefreshTTL")
+	time.Sleep(5 * time.Second)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 3*time.Second)
+	assertNotNil(t, err, "Failed to RefreshTTL")
+	if err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		t.Fatal("RefreshTTL should have failed as due to Key getting expired", err)
+	}
+}
+
+func TestV3CompareAndDel(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	ds := newEtcdV3DataStore(t)
+	defer ds.Close()
+	putIfAbsent(ds, t, "testkey", "testvalue123", 5*time.Second)
+	err := ds.CompareAndDel(context.Background(), "test123", "abcd")
+	assertNotNil(t, err, "Should have failed for a non existent key")
+	if err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		t.Fatal("Failed with wrong error code, should  have been KeyNotFound", err)
+	}
+	err = ds.CompareAndDel(context.Background(), "testkey", "abcd")
+	assertNotNil(t, err, "Should have failed as value is not same")
+	if err.(kingsmoot.Error).Code() != kingsmoot.CompareFailed {
+		t.Fatal("Failed with wrong error code, should  have been CompareFailed", err)
+	}
+	err = ds.CompareAndDel(context.Background(), "testkey", "testvalue123")
+	assertNil(t, err, "CompareAndDel should have been successful")
+}
+
+func TestV3Watch(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	ds := newEtcdV3DataStore(t)
+	defer ds.Close()
+	putIfAbsent(ds, t, "testkey", "testvalue123", 2*time.Second)
+	l := newListener()
+	err := ds.Watch(context.Background(), "testkey", l)
+	assertNil(t, err, "Error while setting the Watch")
+	c, err := whatChanged(l.changeCh, 5*time.Second)
+	assertNil(t, err, "1:Should have got expire notification within 5 seconds")
+	if c.ChangeType != kingsmoot.Deleted || c.PrevValue != "testvalue123" {
+		t.Fatalf("Expected %v of %v Got %v", kingsmoot.Deleted, "testvalue123", c)
+	}
+	putIfAbsent(ds, t, "testkey", "testvalue456", 5*time.Second)
+	c, err = whatChanged(l.changeCh, 2*time.Second)
+	assertNil(t, err, "2:Should have got change notification within 2 seconds")
+	if c.ChangeType != kingsmoot.Created || c.NewValue != "testvalue456" {
+		t.Fatalf("Expected %v of %v Got %v", kingsmoot.Created, "testvalue456", c)
+	}
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue456", 5*time.Second)
+	assertNil(t, err, "3:Should have refreshed ttl")
+	c, err = whatChanged(l.changeCh, 1*time.Second)
+	assertNotNil(t, err, "4:Should not have got change notification for ttl refresh")
+	err = ds.CompareAndDel(context.Background(), "testkey", "testvalue456")
+	assertNil(t, err, "5:Should have deleted the key")
+	c, err = whatChanged(l.changeCh, 2*time.Second)
+	assertNil(t, err, "6:Should have got change notification within 2 seconds")
+	if c.ChangeType != kingsmoot.Deleted || c.PrevValue != "testvalue456" {
+		t.Fatalf("Expected %v of %v Got %v", kingsmoot.Deleted, "testvalue456", c)
+	}
+}
+
+func TestV3WatchFromCall(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	ds := newEtcdV3DataStore(t)
+	defer ds.Close()
+	l := newListener()
+	assertNil(t, ds.Watch(context.Background(), "testkey", l), "Error while setting the Watch")
+	// Written right away, likely before the watch stream is up
+	putIfAbsent(ds, t, "testkey", "testvalue123", 2*time.Second)
+	c, err := whatChanged(l.changeCh, 2*time.Second)
+	assertNil(t, err, "1:Should have got change notification within 2 seconds")
+	if c.ChangeType != kingsmoot.Created || c.NewValue != "testvalue123" {
+		t.Fatalf("Expected %v of %v Got %v", kingsmoot.Created, "testvalue123", c)
+	}
+}
+
+func TestV3JoinAsCandidate(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(testV3Conf())
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	err = km1.Join(c1.endpoint, c1)
+	assertNil(t, err, "2:Failed to join leader election")
+	defer km1.Exit()
+	state, err := readState(c1.roleCh, 20*time.Millisecond)
+	assertNil(t, err, fmt.Sprintf("3:Failed to get notification for %v", c1))
+	if state != kingsmoot.Leader {
+		t.Fatalf("%v should have been leader", c1)
+	}
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(testV3Conf())
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	err = km2.Join(c2.endpoint, c2)
+	assertNil(t, err, "5:Failed to join leader election")
+	defer km2.Exit()
+	state, err = readState(c2.roleCh, 20*time.Millisecond)
+	assertNil(t, err, fmt.Sprintf("6:Failed to get notification for %v", c2))
+	if state != kingsmoot.Follower {
+		t.Fatalf("Should have been follower %v", c2)
+	}
+	km1.Exit()
+	state, err = readState(c2.roleCh, 2*time.Second)
+	assertNil(t, err, fmt.Sprintf("7:Failed to get notification for %v to become leader", c2))
+	if state != kingsmoot.Leader {
+		t.Fatalf("Should have been Leader %v", c2)
+	}
+}
+
+func TestV3SessionSharesLease(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{v3ClientURL}, DialTimeout: time.Second})
+	assertNil(t, err, "1:Failed to create client")
+	defer client.Close()
+	leaseOf := func(key string) int64 {
+		resp, err := client.Get(context.Background(), key)
+		assertNil(t, err, "Failed to get "+key)
+		if len(resp.Kvs) == 0 {
+			return 0
+		}
+		return resp.Kvs[0].Lease
+	}
+	s, err := kingsmoot.NewSession(testV3Conf())
+	assertNil(t, err, "2:Failed to create session")
+	c1, c2 := CreateCandidate("akem1:6379"), CreateCandidate("akem2:6379")
+	km1, km2 := s.Election("akem"), s.Election("akem.other")
+	assertNil(t, km1.Join(c1.endpoint, c1), "3:Failed to join leader election")
+	assertNil(t, km2.Join(c2.endpoint, c2), "4:Failed to join leader election")
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "5")
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 20*time.Millisecond, "6")
+	lease := leaseOf("akem")
+	if lease == 0 || lease != leaseOf("akem.other") {
+		t.Fatalf("7:Expected keys to share a lease Got %v and %v", lease, leaseOf("akem.other"))
+	}
+	// Refreshes keep the shared lease, and both keys, alive past their TTL
+	select {
+	case state := <-c1.roleCh:
+		t.Fatalf("8:Leader should have stayed leader Got %v", state)
+	case state := <-c2.roleCh:
+		t.Fatalf("8:Leader should have stayed leader Got %v", state)
+	case <-time.After(2 * testV3Conf().MasterDownAfter):
+	}
+	km1.Exit()
+	if leaseOf("akem") != 0 || leaseOf("akem.other") == 0 {
+		t.Fatal("9:Exit should have deleted only its own key")
+	}
+	km2.Exit()
+	assertNil(t, s.Close(), "10:Failed to close session")
+	resp, err := client.TimeToLive(context.Background(), clientv3.LeaseID(lease))
+	assertNil(t, err, "11:Failed to look up lease")
+	if resp.TTL != -1 {
+		t.Fatalf("12:Close should have revoked the shared lease Got TTL %v", resp.TTL)
+	}
+}
+
+func TestV3SessionAgedLease(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{v3ClientURL}, DialTimeout: time.Second})
+	assertNil(t, err, "1:Failed to create client")
+	defer client.Close()
+	ttlOf := func(lease int64) int64 {
+		resp, err := client.TimeToLive(context.Background(), clientv3.LeaseID(lease))
+		assertNil(t, err, "Failed to look up lease")
+		return resp.TTL
+	}
+	conf := testV3Conf()
+	conf.MasterDownAfter = 6 * time.Second
+	s, err := kingsmoot.NewSession(conf)
+	assertNil(t, err, "2:Failed to create session")
+	defer s.Close()
+	c1, c2 := CreateCandidate("akem1:6379"), CreateCandidate("akem2:6379")
+	km1, km2 := s.Election("akem"), s.Election("akem.other")
+	assertNil(t, km1.Join(c1.endpoint, c1), "3:Failed to join leader election")
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "4")
+	resp, err := client.Get(context.Background(), "akem")
+	assertNil(t, err, "5:Failed to get akem")
+	lease := resp.Kvs[0].Lease
+	// Nothing keeps the shared lease alive once km1 is gone, let it age
+	km1.Exit()
+	timeout := time.After(10 * time.Second)
+	for ttlOf(lease) > 2 {
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("6:Shared lease should have aged")
+		}
+	}
+	assertNil(t, km2.Join(c2.endpoint, c2), "7:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 20*time.Millisecond, "8")
+	resp, err = client.Get(context.Background(), "akem.other")
+	assertNil(t, err, "9:Failed to get akem.other")
+	if resp.Kvs[0].Lease != lease {
+		t.Fatalf("10:Expected akem.other on the shared lease %v Got %v", lease, resp.Kvs[0].Lease)
+	}
+	if ttl := ttlOf(lease); ttl < 4 {
+		t.Fatalf("11:Shared lease should have been kept alive once the key was attached Got TTL %v", ttl)
+	}
+}
+
+func TestV3ListAndWatchPrefix(t *testing.T) {
+	defer startEmbeddedEtcd(t)()
+	ds := newEtcdV3DataStore(t)
+	defer ds.Close()
+	listAndWatchPrefix(t, ds)
+}
@@ -0,0 +1,185 @@
+package kingsmoot
+
+import (
+	"fmt"
+	"sync"
+)
+
+type EventType int8
+
+const (
+	RoleChanged EventType = 1 + iota
+	LeaderChanged
+	RefreshFailed
+	DataStoreDisconnected
+	DataStoreReconnected
+	WatchRestarted
+)
+
+var eventTypes = []string{
+	"RoleChanged",
+	"LeaderChanged",
+	"RefreshFailed",
+	"DataStoreDisconnected",
+	"DataStoreReconnected",
+	"WatchRestarted"}
+
+func (et EventType) String() string {
+	return eventTypes[et-1]
+}
+
+// Event is something that happened to a candidate in the election. MemberShip is the
+// membership of the candidate once the event happened, Err is the error behind
+// RefreshFailed, DataStoreDisconnected and WatchRestarted.
+type Event struct {
+	Type       EventType
+	MemberShip MemberShip
+	Err        error
+}
+
+func (e Event) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v:%v:Leader[%v]:Term[%v]:%v", e.Type, e.MemberShip.Role, e.MemberShip.Leader, e.MemberShip.Term, e.Err)
+	}
+	return fmt.Sprintf("%v:%v:Leader[%v]:Term[%v]", e.Type, e.MemberShip.Role, e.MemberShip.Leader, e.MemberShip.Term)
+}
+
+// eventBus hands events to subscribers in order from its own goroutine, so that a slow
+// subscriber never holds up the election. It is closed once the candidate is Dead, after
+// the events published till then have been delivered.
+type eventBus struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []Event
+	subs   map[int]func(Event)
+	chans  map[int]chan Event
+	nextID int
+	closed bool
+}
+
+func newEventBus() *eventBus {
+	b := &eventBus{subs: make(map[int]func(Event)), chans: make(map[int]chan Event)}
+	b.cond = sync.NewCond(&b.mu)
+	go b.run()
+	return b
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.events = append(b.events, e)
+	b.cond.Signal()
+}
+
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Signal()
+}
+
+func (b *eventBus) subscribe(f func(Event), ch chan Event) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = f
+	if ch != nil {
+		if b.closed && len(b.events) == 0 {
+			close(ch)
+		} else {
+			b.chans[id] = ch
+		}
+	}
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+func (b *eventBus) run() {
+	for {
+		b.mu.Lock()
+		for len(b.events) == 0 && !b.closed {
+			b.cond.Wait()
+		}
+		if len(b.events) == 0 {
+			for id, ch := range b.chans {
+				close(ch)
+				delete(b.chans, id)
+			}
+			b.mu.Unlock()
+			return
+		}
+		e := b.events[0]
+		b.events = b.events[1:]
+		subs := make([]func(Event), 0, len(b.subs))
+		for _, f := range b.subs {
+			subs = append(subs, f)
+		}
+		b.mu.Unlock()
+		for _, f := range subs {
+			f(e)
+		}
+	}
+}
+
+// Subscribe registers f to be called with every event from now on, in the order they
+// happened. Calls are made one at a time from a goroutine of Kingsmoot, a slow f delays
+// the events of other subscribers but never the election. The returned func unsubscribes.
+func (km *Kingsmoot) Subscribe(f func(Event)) (unsubscribe func()) {
+	return km.events.subscribe(f, nil)
+}
+
+// Events returns a channel of every event from now on. The channel is closed once the
+// candidate is Dead and must be drained till then, or other subscribers are held up.
+func (km *Kingsmoot) Events() <-chan Event {
+	ch := make(chan Event, 16)
+	km.events.subscribe(func(e Event) { ch <- e }, ch)
+	return ch
+}
+
+// publish must be called with km.mu held
+func (km *Kingsmoot) publish(t EventType, err error) {
+	switch t {
+	case RoleChanged:
+		roleGauge.set(float64(km.role), km.conf.Name)
+		if km.role == Leader {
+			electionsWon.inc(km.conf.Name)
+		}
+	case LeaderChanged:
+		leaderChanges.inc(km.conf.Name)
+	case RefreshFailed:
+		refreshFailures.inc(km.conf.Name)
+	case WatchRestarted:
+		watchRestarts.inc(km.conf.Name)
+	}
+	km.events.publish(Event{Type: t, MemberShip: MemberShip{Role: km.role, Leader: km.currLeader, Term: km.term, Record: km.currRecord}, Err: err})
+}
+
+// observeDs publishes DataStoreDisconnected when an operation on the datastore fails for
+// reasons other than the state of the key, and DataStoreReconnected on the first one to
+// get through after that. Must be called with km.mu held.
+func (km *Kingsmoot) observeDs(err error) {
+	down := false
+	if err != nil {
+		switch err.(Error).Code() {
+		case KeyExists, KeyNotFound, CompareFailed:
+		default:
+			down = true
+		}
+	}
+	if down == km.dsDown {
+		return
+	}
+	km.dsDown = down
+	if down {
+		km.publish(DataStoreDisconnected, err)
+	} else {
+		km.publish(DataStoreReconnected, nil)
+	}
+}
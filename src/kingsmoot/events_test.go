@@ -0,0 +1,136 @@
+package kingsmoot_test
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingDataStore fails RefreshTTL while failing is set, like a datastore that went away
+type failingDataStore struct {
+	kingsmoot.DataStore
+	failing *int32
+}
+
+func (f *failingDataStore) RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if atomic.LoadInt32(f.failing) == 1 {
+		return unreachable{}
+	}
+	return f.DataStore.RefreshTTL(ctx, key, value, ttl)
+}
+
+type unreachable struct{}
+
+func (unreachable) Code() kingsmoot.ErrorCode { return kingsmoot.DataStoreError }
+func (unreachable) Message() string           { return "Datastore unreachable" }
+func (unreachable) Cause() error              { return nil }
+func (unreachable) Error() string             { return "DataStoreError:Datastore unreachable" }
+
+func TestEvents(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	c1 := &lastRoleCandidate{endpoint: "akem1:6379"}
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	events1 := km1.Events()
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	e := awaitEvent(t, events1, kingsmoot.RoleChanged, 20*time.Millisecond, "3")
+	if e.MemberShip.Role != kingsmoot.Leader {
+		t.Fatalf("%v should have been leader, Got %v", c1, e)
+	}
+	e = awaitEvent(t, events1, kingsmoot.LeaderChanged, 20*time.Millisecond, "4")
+	if e.MemberShip.Leader != c1.endpoint || e.MemberShip.Term == 0 {
+		t.Fatalf("Expected leader %v with a term, Got %v", c1.endpoint, e)
+	}
+
+	c2 := &lastRoleCandidate{endpoint: "akem2:6379"}
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "5:Failed to create kingsmoot")
+	events2 := make(chan kingsmoot.Event, 16)
+	unsubscribe := km2.Subscribe(func(e kingsmoot.Event) { events2 <- e })
+	assertNil(t, km2.Join(c2.endpoint, c2), "6:Failed to join leader election")
+	defer km2.Exit()
+	e = awaitEvent(t, events2, kingsmoot.LeaderChanged, 20*time.Millisecond, "7")
+	if e.MemberShip.Role != kingsmoot.Follower || e.MemberShip.Leader != c1.endpoint {
+		t.Fatalf("%v should have been follower of %v, Got %v", c2, c1.endpoint, e)
+	}
+
+	km1.Exit()
+	e = awaitEvent(t, events1, kingsmoot.RoleChanged, 20*time.Millisecond, "8")
+	if e.MemberShip.Role != kingsmoot.Dead {
+		t.Fatalf("%v should have been dead, Got %v", c1, e)
+	}
+	select {
+	case _, ok := <-events1:
+		if ok {
+			t.Fatal("9:No event expected after Dead")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("9:Events should have been closed after Dead")
+	}
+	e = awaitEvent(t, events2, kingsmoot.LeaderChanged, 100*time.Millisecond, "10")
+	if e.MemberShip.Role != kingsmoot.Leader || e.MemberShip.Leader != c2.endpoint {
+		t.Fatalf("%v should have been leader, Got %v", c2, e)
+	}
+	unsubscribe()
+	km2.Exit()
+	select {
+	case e := <-events2:
+		t.Fatalf("11:No event expected after unsubscribe, Got %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDataStoreEvents(t *testing.T) {
+	var failing int32
+	kingsmoot.Register("failing", func(ctx context.Context, conf *kingsmoot.Config) (kingsmoot.DataStore, error) {
+		ds, err := kingsmoot.NewMemoryDataStore(ctx, conf)
+		return &failingDataStore{DataStore: ds, failing: &failing}, err
+	})
+	conf := testMemoryConf(t.Name())
+	conf.DataStoreType = "failing"
+	conf.MasterDownAfter = 200 * time.Millisecond
+	c1 := &lastRoleCandidate{endpoint: "akem1:6379"}
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	events := km1.Events()
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitEvent(t, events, kingsmoot.LeaderChanged, 20*time.Millisecond, "3")
+	atomic.StoreInt32(&failing, 1)
+	e := awaitEvent(t, events, kingsmoot.DataStoreDisconnected, time.Second, "4")
+	assertNotNil(t, e.Err, "5:DataStoreDisconnected should carry the error")
+	e = awaitEvent(t, events, kingsmoot.RefreshFailed, 20*time.Millisecond, "6")
+	assertNotNil(t, e.Err, "7:RefreshFailed should carry the error")
+	e = awaitEvent(t, events, kingsmoot.RoleChanged, 20*time.Millisecond, "8")
+	if e.MemberShip.Role != kingsmoot.NotAMember {
+		t.Fatalf("%v should have been NotAMember, Got %v", c1, e)
+	}
+	atomic.StoreInt32(&failing, 0)
+	awaitEvent(t, events, kingsmoot.DataStoreReconnected, time.Second, "9")
+	e = awaitEvent(t, events, kingsmoot.RoleChanged, 20*time.Millisecond, "10")
+	if e.MemberShip.Role != kingsmoot.Leader {
+		t.Fatalf("%v should have been leader again, Got %v", c1, e)
+	}
+}
+
+// awaitEvent skips events till one of the expected type arrives
+func awaitEvent(t *testing.T, events <-chan kingsmoot.Event, expected kingsmoot.EventType, timeout time.Duration, step string) kingsmoot.Event {
+	timer := time.After(timeout)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal(fmt.Sprintf("%v:Events closed while waiting for %v", step, expected))
+			}
+			if e.Type == expected {
+				return e
+			}
+		case <-timer:
+			t.Fatal(errors.New(fmt.Sprintf("%v:Did not get %v within %v", step, expected, timeout)))
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package kingsmoot
+
+import (
+	"flag"
+	"strings"
+	"time"
+)
+
+// RegisterFlags binds the fields of conf which can be given on the command line to flags
+// of fs, with the current values of conf as defaults
+func (conf *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&conf.Name, "name", conf.Name, "Name of the service, the key of the election")
+	fs.StringVar(&conf.DataStoreType, "datastore", conf.DataStoreType, "Type of the datastore, one of etcdv2, etcdv3 or memory")
+	fs.Var((*addressesFlag)(&conf.Addresses), "addresses", "Comma separated http://host:port of the seed servers of the datastore")
+	fs.DurationVar(&conf.DsOpTimeout, "ds-op-timeout", conf.DsOpTimeout, "Timeout of every datastore operation")
+	fs.DurationVar(&conf.MasterDownAfter, "master-down-after", conf.MasterDownAfter, "TTL of the leader key")
+	fs.DurationVar(&conf.LeaseSafetyMargin, "lease-safety-margin", conf.LeaseSafetyMargin, "How long before the leader key expires that the leader gives up, a tenth of master-down-after if zero")
+	fs.DurationVar(&conf.StepDownCooldown, "step-down-cooldown", conf.StepDownCooldown, "How long a candidate which stepped down stays away from the election")
+	fs.IntVar(&conf.UnhealthyThreshold, "unhealthy-threshold", conf.UnhealthyThreshold, "Health checks a leader fails in a row before it steps down, 3 if zero")
+}
+
+// DefaultConfig is the Config of New, without name and addresses
+func DefaultConfig() *Config {
+	return &Config{DataStoreType: "etcdv2", DsOpTimeout: 500 * time.Millisecond, MasterDownAfter: 30 * time.Second}
+}
+
+type addressesFlag []string
+
+func (a *addressesFlag) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *addressesFlag) Set(value string) error {
+	*a = strings.Split(value, ",")
+	return nil
+}
@@ -0,0 +1,111 @@
+package kingsmoot
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Status is what a candidate knows of the election, as seen from its process
+type Status struct {
+	Service  string `json:"service"`
+	Endpoint string `json:"endpoint"`
+	Role     string `json:"role"`
+	Leader   string `json:"leader"`
+	Term     uint64 `json:"term"`
+	// LastRefresh is when the leader key was last written or refreshed by this candidate,
+	// zero if it never led
+	LastRefresh time.Time `json:"lastRefresh"`
+	// DataStoreHealthy is false from the first failure of an operation on the datastore
+	// till one gets through again
+	DataStoreHealthy bool      `json:"dataStoreHealthy"`
+	PausedUntil      time.Time `json:"pausedUntil"`
+}
+
+func (km *Kingsmoot) Status() Status {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	s := Status{Service: km.conf.Name, Endpoint: km.endpoint, Role: km.role.String(), Leader: km.currLeader,
+		Term: km.term, LastRefresh: km.refreshedAt, DataStoreHealthy: !km.dsDown}
+	if km.conf.clock().Now().Before(km.suppressUntil) {
+		s.PausedUntil = km.suppressUntil
+	}
+	return s
+}
+
+// Handler serves the Status of the candidate as JSON, and lets operators act on it:
+//
+//	GET  /status                  Status
+//	GET  /leader                  Status, with 503 Service Unavailable unless Leader
+//	POST /stepdown                StepDown
+//	POST /pause?duration=<d>      Pause for d, as parsed by time.ParseDuration
+//	POST /resume                  Resume
+//
+// Paths are relative to where it is mounted, use http.StripPrefix to mount it under a
+// prefix. Actions answer with the Status following them.
+func (km *Kingsmoot) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", km.serveStatus(http.StatusOK))
+	mux.HandleFunc("/leader", func(w http.ResponseWriter, r *http.Request) {
+		code := http.StatusServiceUnavailable
+		if role, _ := km.current(); role == Leader {
+			code = http.StatusOK
+		}
+		km.serveStatus(code)(w, r)
+	})
+	mux.HandleFunc("/stepdown", km.serveAction(func(r *http.Request) (int, error) {
+		if err := km.StepDown(r.Context()); err != nil {
+			return http.StatusConflict, err
+		}
+		return http.StatusOK, nil
+	}))
+	mux.HandleFunc("/pause", km.serveAction(func(r *http.Request) (int, error) {
+		d, err := time.ParseDuration(r.FormValue("duration"))
+		if err == nil {
+			err = km.Pause(r.Context(), d)
+		}
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		return http.StatusOK, nil
+	}))
+	mux.HandleFunc("/resume", km.serveAction(func(r *http.Request) (int, error) {
+		km.Resume()
+		return http.StatusOK, nil
+	}))
+	return mux
+}
+
+func (km *Kingsmoot) serveStatus(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		writeJSON(w, code, km.Status())
+	}
+}
+
+// serveAction runs action on POST and answers with the Status after it, or with the
+// error it failed with
+func (km *Kingsmoot) serveAction(action func(r *http.Request) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		code, err := action(r)
+		if err != nil {
+			km.log().Infof("%v %v failed due to %v", r.Method, r.URL.Path, err)
+			writeJSON(w, code, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, code, km.Status())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,72 @@
+package kingsmoot_test
+
+import (
+	"encoding/json"
+	"kingsmoot"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func request(t *testing.T, h http.Handler, method string, path string) (int, kingsmoot.Status) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	var status kingsmoot.Status
+	assertNil(t, json.Unmarshal(w.Body.Bytes(), &status), "Failed to decode status")
+	return w.Code, status
+}
+
+func TestHandler(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	conf.StepDownCooldown = time.Minute
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2), "5:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "6")
+	h1, h2 := km1.Handler(), km2.Handler()
+
+	code, status := request(t, h1, "GET", "/status")
+	if code != http.StatusOK || status.Role != "Leader" || status.Leader != c1.endpoint || status.Term == 0 ||
+		status.LastRefresh.IsZero() || !status.DataStoreHealthy {
+		t.Fatalf("7:Unexpected status %v %+v", code, status)
+	}
+	if code, _ = request(t, h1, "GET", "/leader"); code != http.StatusOK {
+		t.Fatalf("8:Leader should answer 200 Got %v", code)
+	}
+	if code, status = request(t, h2, "GET", "/leader"); code != http.StatusServiceUnavailable || status.Leader != c1.endpoint {
+		t.Fatalf("9:Follower should answer 503 Got %v %+v", code, status)
+	}
+	if code, _ = request(t, h2, "POST", "/stepdown"); code != http.StatusConflict {
+		t.Fatalf("10:Follower should not be able to step down Got %v", code)
+	}
+	if code, _ = request(t, h1, "GET", "/stepdown"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("11:Actions should be POST only Got %v", code)
+	}
+	if code, _ = request(t, h1, "POST", "/pause?duration=soon"); code != http.StatusBadRequest {
+		t.Fatalf("12:Pause should need a duration Got %v", code)
+	}
+
+	code, status = request(t, h1, "POST", "/pause?duration=1h")
+	if code != http.StatusOK || status.Role != "Follower" || status.PausedUntil.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("13:Leader should have stepped down and paused Got %v %+v", code, status)
+	}
+	awaitState(t, c1.roleCh, kingsmoot.Follower, 20*time.Millisecond, "14")
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 100*time.Millisecond, "15")
+	if code, status = request(t, h1, "POST", "/resume"); code != http.StatusOK || !status.PausedUntil.IsZero() {
+		t.Fatalf("16:Failed to resume Got %v %+v", code, status)
+	}
+	code, _ = request(t, h2, "POST", "/stepdown")
+	if code != http.StatusOK {
+		t.Fatalf("17:Failed to step down Got %v", code)
+	}
+	awaitState(t, c1.roleCh, kingsmoot.Leader, conf.MasterDownAfter, "18")
+}
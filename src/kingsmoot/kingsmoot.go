@@ -3,7 +3,11 @@ package kingsmoot
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 type Role int8
@@ -31,205 +35,768 @@ type Config struct {
 	Addresses       []string
 	DsOpTimeout     time.Duration
 	MasterDownAfter time.Duration
-	CustomConf      map[string]string
+	// LeaseSafetyMargin is how long before the leader key can expire in the datastore that
+	// the leader gives up leadership on its own, if it has not been able to refresh it.
+	// Defaults to a tenth of MasterDownAfter.
+	LeaseSafetyMargin time.Duration
+	// StepDownCooldown is how long a candidate which stepped down stays away from the
+	// election before campaigning again. Zero lets it campaign right away.
+	StepDownCooldown time.Duration
+	// UnhealthyThreshold is how many health checks in a row a leader which is a
+	// HealthChecker has to fail before it steps down. Defaults to 3.
+	UnhealthyThreshold int
+	// Logger is what the Kingsmoot logs through, with service, endpoint, role, leader and
+	// term as fields. Defaults to the package loggers if Init was called, to no logging
+	// otherwise.
+	Logger Logger
+	// Labels are written into the LeaderRecord of the candidate when it leads
+	Labels map[string]string
+	// Clock is what the Kingsmoot and the MemoryDataStore tell time by. Defaults to the
+	// time of the system.
+	Clock      Clock
+	CustomConf map[string]string
+}
+
+func (conf *Config) logger() Logger {
+	if conf.Logger != nil {
+		return conf.Logger
+	}
+	return defaultLogger()
+}
+
+func (conf *Config) unhealthyThreshold() int {
+	if conf.UnhealthyThreshold > 0 {
+		return conf.UnhealthyThreshold
+	}
+	return 3
+}
+
+func (conf *Config) leaseSafetyMargin() time.Duration {
+	if conf.LeaseSafetyMargin > 0 {
+		return conf.LeaseSafetyMargin
+	}
+	return conf.MasterDownAfter / 10
 }
 
 type MemberShip struct {
 	Role   Role
 	Leader string
+	// Term is the fencing token of the current leadership term. It is the datastore index
+	// at which the leader key was created, so it only ever grows from one term to the next.
+	Term uint64
+	// Record is what the leader wrote into the leader key, empty while there is no leader
+	Record LeaderRecord
 }
+
+// Candidate is told about every change of its MemberShip, in order, from a goroutine of
+// Kingsmoot and with no lock held, so it may call back into the Kingsmoot. A Candidate
+// failing to take up Leader or Follower is kicked out of the election.
 type Candidate interface {
 	fmt.Stringer
 	UpdateMembership(memberShip MemberShip) error
 }
 
+// HealthChecker can be implemented by a Candidate which is fit to lead only while more than
+// its process is up, like while its downstream dependencies are reachable. CheckHealth is
+// called before every refresh of leadership and every campaign, and is given at most
+// LeaseSafetyMargin. A leader failing UnhealthyThreshold checks in a row steps down, a
+// follower failing its latest check does not campaign.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// Observer is told about the leader of an election it follows without taking part in it,
+// like the Follower of the Java client. Calls are made the way they are to a Candidate.
+type Observer interface {
+	OnLeaderElect(leader string)
+	OnLeaderDeath()
+}
+
 type Kingsmoot struct {
-	conf       *Config
-	endpoint   string
-	c          Candidate
-	role       Role
-	currLeader string
-	ds         DataStore
-	quitCh     chan bool
+	conf          *Config
+	ds            DataStore
+	ctx           context.Context //Cancelled on Exit, bounds everything loop does
+	cancel        context.CancelFunc
+	putFailed     bool         //Last campaign failed on the datastore, only touched by joinLeaderElection, which never runs concurrently
+	mayLead       func() bool  //Set before Join, keeps the candidate from campaigning when false
+	logger        atomic.Value //Logger with the fields of the current state
+	mu            sync.Mutex   //Protects everything below
+	endpoint      string
+	c             Candidate
+	priority      int
+	o             Observer
+	role          Role
+	currLeader    string
+	term          uint64
+	currRecord    LeaderRecord
+	value         string //Value of the leader key written by this candidate, when it last led
+	member        string //Value of the member key of this candidate
+	leaseTimer    Timer
+	suppressUntil time.Time
+	refusedLead   bool   //Set once the Candidate refused Leader, keeps it from campaigning till Resume
+	steppingDown  string //Value of the leader key StepDown is deleting
+	leaderSince   time.Time
+	refreshedAt   time.Time //When the leader key was last written or refreshed by this candidate
+	dsDown        bool
+	unhealthy     int //Health checks failed in a row
+	events        *eventBus
+	calls         *callQueue
 }
 
 func New(name string, addresses []string) (*Kingsmoot, error) {
-	conf := &Config{Name: name, DataStoreType: "etcdv2", Addresses: addresses, DsOpTimeout: 500 * time.Millisecond, MasterDownAfter: 30 * time.Second}
-	ds, err := CreateDatastore(conf)
-	if nil != err {
-		Info.Println("Could not connet to datastore Error: ", err)
-		return nil, err
-	}
-	return &Kingsmoot{conf: conf, ds: ds, quitCh: make(chan bool, 1)}, nil
+	return NewContext(context.Background(), name, addresses)
+}
+
+// NewContext is New with ctx bounding the connection to the datastore
+func NewContext(ctx context.Context, name string, addresses []string) (*Kingsmoot, error) {
+	conf := DefaultConfig()
+	conf.Name, conf.Addresses = name, addresses
+	return NewFromConfContext(ctx, conf)
 }
 
 func NewFromConf(conf *Config) (*Kingsmoot, error) {
-	ds, err := CreateDatastore(conf)
+	return NewFromConfContext(context.Background(), conf)
+}
+
+// NewFromConfContext is NewFromConf with ctx bounding the connection to the datastore
+func NewFromConfContext(ctx context.Context, conf *Config) (*Kingsmoot, error) {
+	ds, err := CreateDatastoreContext(ctx, conf)
 	if nil != err {
-		Info.Println("Could not connet to datastore Error: ", err)
-		ds.Close()
+		conf.logger().With(Fields{"service": conf.Name}).Errorf("Could not connet to datastore Error: %v", err)
 		return nil, err
 	}
-	return &Kingsmoot{conf: conf, ds: ds, quitCh: make(chan bool, 1)}, nil
+	return newKingsmoot(conf, ds), nil
 }
 
-func (km *Kingsmoot) Join(endpoint string, c Candidate) error {
-	if km.isDead() {
+// NewFromDataStore creates a Kingsmoot on a DataStore of the caller, like one wrapped for a
+// test, rather than on one created from conf. Exit closes ds.
+func NewFromDataStore(conf *Config, ds DataStore) *Kingsmoot {
+	return newKingsmoot(conf, instrument(ds, conf.Name))
+}
+
+func newKingsmoot(conf *Config, ds DataStore) *Kingsmoot {
+	km := &Kingsmoot{conf: conf, ds: ds, events: newEventBus(), calls: newCallQueue()}
+	km.ctx, km.cancel = context.WithCancel(context.Background())
+	km.updateLogger()
+	return km
+}
+
+func (km *Kingsmoot) Join(endpoint string, c Candidate, opts ...JoinOption) error {
+	return km.JoinContext(context.Background(), endpoint, c, opts...)
+}
+
+// JoinContext is Join with ctx bounding the first round of election. Once joined, the
+// candidate stays in the election till Exit.
+func (km *Kingsmoot) JoinContext(ctx context.Context, endpoint string, c Candidate, opts ...JoinOption) error {
+	km.mu.Lock()
+	if km.role == Dead {
+		km.mu.Unlock()
 		return errors.New("Kingsmoot closed, create new instance to join")
 	}
-	if km.endpoint != "" {
+	if km.endpoint != "" || km.o != nil {
+		km.mu.Unlock()
 		return errors.New(fmt.Sprintf("Already in use for %v, create new instance to join", km.endpoint))
 	}
 	km.endpoint = endpoint
 	km.c = c
-	if err := km.joinLeaderElection(); err != nil {
+	for _, opt := range opts {
+		opt(km)
+	}
+	km.updateLogger()
+	km.mu.Unlock()
+	if err := km.register(ctx); err != nil {
 		return err
 	}
-	go km.candidateLoop()
+	// Watch before campaigning, so that no change after the campaign is missed
+	l := km.registerListener()
+	if err := km.joinLeaderElection(ctx); err != nil {
+		return err
+	}
+	go km.loop(l, km.campaign)
+	return nil
+}
+
+// Observe follows the leader of the election without ever campaigning for it. o is told
+// whenever a leader is elected or the leader key goes away, till Exit.
+func (km *Kingsmoot) Observe(o Observer) error {
+	return km.ObserveContext(context.Background(), o)
+}
+
+// ObserveContext is Observe with ctx bounding the first lookup of the leader
+func (km *Kingsmoot) ObserveContext(ctx context.Context, o Observer) error {
+	km.mu.Lock()
+	if km.role == Dead {
+		km.mu.Unlock()
+		return errors.New("Kingsmoot closed, create new instance to observe")
+	}
+	if km.endpoint != "" || km.o != nil {
+		km.mu.Unlock()
+		return errors.New(fmt.Sprintf("Already in use for %v, create new instance to observe", km.endpoint))
+	}
+	km.o = o
+	km.mu.Unlock()
+	l := km.registerListener()
+	if err := km.observeLeader(ctx); err != nil {
+		return err
+	}
+	go km.loop(l, km.observeLeader)
 	return nil
 }
 
 func (km *Kingsmoot) Leader() (string, error) {
-	return km.ds.Get(km.conf.Name)
+	return km.LeaderContext(context.Background())
+}
+
+func (km *Kingsmoot) LeaderContext(ctx context.Context) (string, error) {
+	record, err := km.LeaderRecordContext(ctx)
+	return record.Endpoint, err
+}
+
+// LeaderRecord returns what the current leader wrote into the leader key
+func (km *Kingsmoot) LeaderRecord() (LeaderRecord, error) {
+	return km.LeaderRecordContext(context.Background())
 }
 
-func (km *Kingsmoot) isDead() bool {
-	return km.role == Dead
+func (km *Kingsmoot) LeaderRecordContext(ctx context.Context) (LeaderRecord, error) {
+	value, token, err := km.ds.Get(ctx, km.conf.Name)
+	if err != nil {
+		return LeaderRecord{}, err
+	}
+	return decodeLeaderRecord(value, token), nil
 }
 
-func (km *Kingsmoot) setRole(role Role) {
-	km.role = role
+// WatchLeader calls f with the LeaderRecord of the election right away and then on every
+// change of leader, with an empty one while there is none, till ctx is done or Exit. Calls
+// are made one at a time from a goroutine of Kingsmoot.
+func (km *Kingsmoot) WatchLeader(ctx context.Context, f func(leader LeaderRecord)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-km.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	l := &KeyChangeListener{changeCh: make(chan *Change, 1), errCh: make(chan error, 1)}
+	if err := km.ds.Watch(ctx, km.conf.Name, l); err != nil {
+		cancel()
+		return err
+	}
+	leader, err := km.LeaderRecordContext(ctx)
+	if err != nil && err.(Error).Code() != KeyNotFound {
+		cancel()
+		return err
+	}
+	go func() {
+		defer cancel()
+		f(leader)
+		for {
+			select {
+			case <-km.conf.clock().After(km.conf.MasterDownAfter / 2):
+			case <-l.changeCh:
+			case <-ctx.Done():
+				return
+			case err := <-l.errCh:
+				km.log().Infof("Watch of leader ended due to %v", err)
+				select {
+				case <-km.conf.clock().After(km.conf.MasterDownAfter):
+				case <-ctx.Done():
+					return
+				}
+				if err := km.ds.Watch(ctx, km.conf.Name, l); err != nil {
+					l.Bye(err)
+				}
+			}
+			next, err := km.LeaderRecordContext(ctx)
+			if err != nil && err.(Error).Code() != KeyNotFound {
+				continue
+			}
+			if next.Endpoint == leader.Endpoint && next.Term == leader.Term {
+				continue
+			}
+			leader = next
+			f(leader)
+		}
+	}()
+	return nil
 }
 
 func (km *Kingsmoot) Exit() {
-	if km.isDead() {
-		return
+	km.ExitContext(context.Background())
+}
+
+// ExitContext is Exit with ctx bounding the release of leadership. In-flight datastore
+// calls of the candidate are cancelled right away.
+func (km *Kingsmoot) ExitContext(ctx context.Context) error {
+	km.mu.Lock()
+	if km.role == Dead {
+		km.mu.Unlock()
+		return nil
+	}
+	km.transition(Dead, LeaderRecord{})
+	value, member, endpoint := km.value, km.member, km.endpoint
+	km.mu.Unlock()
+	km.cancel()
+	if member != "" {
+		km.ds.CompareAndDel(ctx, km.memberKey(endpoint), member)
+	}
+	if claim := km.claim(); claim != "" {
+		km.ds.CompareAndDel(ctx, km.preemptKey(), claim)
+	}
+	var exitErr error
+	var err error
+	if value != "" {
+		err = km.ds.CompareAndDel(ctx, km.conf.Name, value)
 	}
-	km.setRole(Dead)
-	close(km.quitCh)
-	err := km.ds.CompareAndDel(km.conf.Name, km.endpoint)
 	if nil != err {
 		switch err.(Error).Code() {
 		case CompareFailed, KeyNotFound:
 		default:
-			Warning.Println("Error while exitting from kingsmoot", err)
+			km.log().Warnf("Error while exitting from kingsmoot %v", err)
+			exitErr = err
 		}
 	}
 	err = km.ds.Close()
 	if nil != err {
-		Warning.Println("Error while exitting from kingsmoot", err)
+		km.log().Warnf("Error while exitting from kingsmoot %v", err)
+		exitErr = err
+	}
+	return exitErr
+}
+
+// StepDown releases leadership: the leader key is deleted if it is still held by this
+// endpoint, the candidate moves to Follower and does not campaign for StepDownCooldown.
+// The cooldown starts before the key is deleted, so that the candidate does not win the
+// election it just left, and holds even if the delete fails.
+func (km *Kingsmoot) StepDown(ctx context.Context) error {
+	km.mu.Lock()
+	role, endpoint, value, term := km.role, km.endpoint, km.value, km.term
+	if role != Leader {
+		km.mu.Unlock()
+		return errors.New(fmt.Sprintf("%v is not the leader of %v, current role is %v", endpoint, km.conf.Name, role))
+	}
+	if until := km.conf.clock().Now().Add(km.conf.StepDownCooldown); until.After(km.suppressUntil) {
+		km.suppressUntil = until
+	}
+	km.steppingDown = value
+	km.mu.Unlock()
+	err := km.ds.CompareAndDel(ctx, km.conf.Name, value)
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.steppingDown = ""
+	if nil != err {
+		switch err.(Error).Code() {
+		case CompareFailed, KeyNotFound:
+		default:
+			return err
+		}
+	}
+	// The candidate may have lost the key meanwhile, or won it again with no cooldown
+	if km.role != Leader || km.value != value || km.term != term {
+		return nil
+	}
+	km.log().Infof("%v stepped down as leader of %v", km.c, km.conf.Name)
+	return km.follow(LeaderRecord{})
+}
+
+// Pause keeps the candidate away from the election for d, stepping down first if it
+// leads, for draining a node before maintenance. Resume ends the pause early.
+func (km *Kingsmoot) Pause(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return &InvalidArgumentError{code: InvalidArgument, Name: "d", Value: d.String(), Expected: "Positive duration"}
+	}
+	km.mu.Lock()
+	km.suppressUntil = km.conf.clock().Now().Add(d)
+	role, c := km.role, km.c
+	km.mu.Unlock()
+	km.log().Infof("%v paused candidacy for %v", c, d)
+	if role == Leader {
+		return km.StepDown(ctx)
+	}
+	return nil
+}
+
+// Resume lets a candidate which was paused, stepped down or refused to lead, campaign again
+// from its next look at the election on
+func (km *Kingsmoot) Resume() {
+	km.mu.Lock()
+	km.suppressUntil = time.Time{}
+	km.refusedLead = false
+	c := km.c
+	km.mu.Unlock()
+	km.log().Infof("%v resumed candidacy", c)
+}
+
+// TransferTo hands leadership over to the follower with the given endpoint. Other
+// candidates stay away from the election until endpoint has taken over, or until
+// MasterDownAfter has passed if it never does.
+func (km *Kingsmoot) TransferTo(endpoint string) error {
+	return km.TransferToContext(context.Background(), endpoint)
+}
+
+func (km *Kingsmoot) TransferToContext(ctx context.Context, endpoint string) error {
+	role, self := km.current()
+	if endpoint == "" || endpoint == self {
+		return &InvalidArgumentError{code: InvalidArgument, Name: "endpoint", Value: endpoint, Expected: "Endpoint of a follower"}
+	}
+	if role != Leader {
+		return errors.New(fmt.Sprintf("%v is not the leader of %v, current role is %v", self, km.conf.Name, role))
+	}
+	if err := km.ds.Del(ctx, km.transferKey()); err != nil && err.(Error).Code() != KeyNotFound {
+		return err
+	}
+	if _, _, err := km.ds.PutIfAbsent(ctx, km.transferKey(), endpoint, km.conf.MasterDownAfter); err != nil {
+		return err
+	}
+	km.log().Infof("%v transferring leadership of %v to %v", self, km.conf.Name, endpoint)
+	return km.StepDown(ctx)
+}
+
+func (km *Kingsmoot) transferKey() string {
+	return km.conf.Name + ".transfer"
+}
+
+// Evict deletes the leader key if endpoint holds it, as if the lease of endpoint had
+// expired, for getting rid of a leader which is stuck. The candidates, endpoint among them
+// if it is still around, campaign again right away. It fails with CompareFailed if
+// endpoint is not the leader.
+func (km *Kingsmoot) Evict(endpoint string) error {
+	return km.EvictContext(context.Background(), endpoint)
+}
+
+func (km *Kingsmoot) EvictContext(ctx context.Context, endpoint string) error {
+	if endpoint == "" {
+		return &InvalidArgumentError{code: InvalidArgument, Name: "endpoint", Value: endpoint, Expected: "Endpoint of the leader"}
 	}
+	value, token, err := km.ds.Get(ctx, km.conf.Name)
+	if err != nil {
+		return err
+	}
+	if leader := decodeLeaderRecord(value, token); leader.Endpoint != endpoint {
+		return &OpError{code: CompareFailed, op: "Evict", cause: errors.New(fmt.Sprintf("%v is the leader of %v, not %v", leader.Endpoint, km.conf.Name, endpoint))}
+	}
+	return km.ds.CompareAndDel(ctx, km.conf.Name, value)
 }
 
-func (km *Kingsmoot) candidateLoop() {
+// mayCampaign tells if the candidate is healthy, may lead at all, is not cooling down after
+// a step down, has not refused to lead and leadership is not being transferred to some other
+// endpoint.
+func (km *Kingsmoot) mayCampaign(ctx context.Context, endpoint string) bool {
+	if km.checkHealth(ctx) > 0 {
+		return false
+	}
+	if km.mayLead != nil && !km.mayLead() {
+		return false
+	}
+	km.mu.Lock()
+	suppressed := km.refusedLead || km.conf.clock().Now().Before(km.suppressUntil)
+	km.mu.Unlock()
+	if suppressed {
+		return false
+	}
+	transferee, _, err := km.ds.Get(ctx, km.transferKey())
+	return err != nil || transferee == endpoint
+}
+
+func (km *Kingsmoot) log() Logger {
+	return km.logger.Load().(Logger)
+}
+
+// updateLogger must be called with km.mu held, or before the Kingsmoot is shared
+func (km *Kingsmoot) updateLogger() {
+	km.logger.Store(km.conf.logger().With(Fields{
+		"service":  km.conf.Name,
+		"endpoint": km.endpoint,
+		"role":     km.role,
+		"leader":   km.currLeader,
+		"term":     km.term}))
+}
+
+// loop runs step every MasterDownAfter/2 and on every change of the leader key, till Exit.
+// A watch which ended is set up again after MasterDownAfter, steps go on meanwhile.
+func (km *Kingsmoot) loop(l *KeyChangeListener, step func(ctx context.Context) error) {
 	var err error
-	l := km.registerListener()
-	for !km.isDead() {
-		switch km.role {
-		case NotAMember, Follower:
-			km.joinLeaderElection()
-		case Leader:
-			km.refreshTTL()
+	var rewatch <-chan time.Time //Set while the watch is down
+	for {
+		if role, _ := km.current(); role == Dead {
+			return
 		}
+		step(km.ctx)
 		select {
-		case <-time.After(km.conf.MasterDownAfter / 2):
+		case <-km.conf.clock().After(km.conf.MasterDownAfter / 2):
 		case change := <-l.changeCh:
-			Info.Printf("Change event received : %v", change)
-		case <-km.quitCh:
-			Info.Println("Quit signal received")
+			km.log().Tracef("Change event received : %v", change)
+		case <-km.ctx.Done():
+			km.log().Tracef("Quit signal received")
 		case err = <-l.errCh:
-			Info.Printf("Error signal received : %v", err)
-			<-time.After(km.conf.MasterDownAfter)
-			km.ds.Watch(km.conf.Name, l)
+			km.log().Infof("Error signal received : %v", err)
+			rewatch = km.conf.clock().After(km.conf.MasterDownAfter)
+		case <-rewatch:
+			rewatch = nil
+			km.watch(l)
+			km.mu.Lock()
+			if km.role != Dead {
+				km.publish(WatchRestarted, err)
+			}
+			km.mu.Unlock()
 		}
+	}
+}
 
+func (km *Kingsmoot) campaign(ctx context.Context) error {
+	km.refreshMember(ctx)
+	role, _ := km.current()
+	switch role {
+	case NotAMember, Follower:
+		if err := km.joinLeaderElection(ctx); err != nil {
+			return err
+		}
+		return km.preempt(ctx)
+	case Leader:
+		if failures := km.checkHealth(ctx); failures >= km.conf.unhealthyThreshold() {
+			km.log().Warnf("%v failed %v health checks in a row, stepping down as leader of %v", km.c, failures, km.conf.Name)
+			return km.StepDown(ctx)
+		}
+		km.refreshTTL(ctx)
+		return km.yield(ctx)
 	}
+	return nil
 }
 
-func (km *Kingsmoot) joinLeaderElection() error {
-	var err error
-	currLeader, err := km.ds.PutIfAbsent(km.conf.Name, km.endpoint, km.conf.MasterDownAfter)
+// checkHealth runs the health check of the Candidate, if it is a HealthChecker, and returns
+// the number of checks failed in a row
+func (km *Kingsmoot) checkHealth(ctx context.Context) int {
+	km.mu.Lock()
+	hc, ok := km.c.(HealthChecker)
+	km.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, km.conf.leaseSafetyMargin())
+	defer cancel()
+	err := hc.CheckHealth(ctx)
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if err == nil {
+		km.unhealthy = 0
+		return 0
+	}
+	km.unhealthy++
+	km.log().Warnf("%v failed health check due to %v, %v in a row", km.c, err, km.unhealthy)
+	return km.unhealthy
+}
+
+// joinLeaderElection campaigns for the leader key and moves the candidate to Leader if it
+// wins, or to Follower of whoever did
+func (km *Kingsmoot) joinLeaderElection(ctx context.Context) error {
+	_, endpoint := km.current()
+	if !km.mayCampaign(ctx, endpoint) {
+		return km.followLeader(ctx)
+	}
+	if km.putFailed {
+		putIfAbsentRetries.inc(km.conf.Name)
+	}
+	km.mu.Lock()
+	priority := km.priority
+	km.mu.Unlock()
+	start := km.conf.clock().Now()
+	value := newLeaderRecord(km.conf, endpoint, priority).encode()
+	prevValue, term, err := km.ds.PutIfAbsent(ctx, km.conf.Name, value, km.conf.MasterDownAfter)
+	km.putFailed = err != nil && err.(Error).Code() != KeyExists
+	leader := decodeLeaderRecord(prevValue, term)
+	if err != nil && err.(Error).Code() == KeyExists && leader.Endpoint == endpoint {
+		// Key was written by this endpoint earlier, its TTL has to be refreshed before the
+		// lease deadline can be trusted
+		start = km.conf.clock().Now()
+		value = prevValue
+		err = km.ds.RefreshTTL(ctx, km.conf.Name, value, km.conf.MasterDownAfter)
+		if err == nil {
+			err = &OpError{code: KeyExists, op: "PutIfAbsent", cause: errors.New("Key already exists")}
+		}
+	}
+	if err == nil {
+		leader = decodeLeaderRecord(value, term)
+		// Leadership was handed over to this endpoint, if at all
+		km.ds.CompareAndDel(ctx, km.transferKey(), endpoint)
+		if claim := km.claim(); claim != "" {
+			km.ds.CompareAndDel(ctx, km.preemptKey(), claim)
+		}
+	}
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if km.role == Dead {
+		if err == nil {
+			// Exit came in while campaigning and had no value of this candidate to delete,
+			// the key just won must not be left to expire
+			km.ds.CompareAndDel(context.Background(), km.conf.Name, value)
+		}
+		return nil
+	}
+	km.observeDs(err)
+	if km.role == Leader {
+		return nil
+	}
 	if err != nil {
 		switch err.(Error).Code() {
 		case KeyExists:
-			if currLeader == km.endpoint {
-				km.currLeader = currLeader
-				return km.lead()
-			} else if currLeader != km.currLeader {
-				km.currLeader = currLeader
-				return km.follow()
+			if leader.Endpoint == endpoint {
+				return km.lead(start, value, leader)
+			} else if km.role != Follower || leader.Endpoint != km.currLeader || leader.Term != km.term {
+				electionsLost.inc(km.conf.Name)
+				return km.follow(leader)
 			}
 		default:
-			Info.Printf("Leader election failed due to %v, going to kick out from election", err)
+			km.log().Infof("Leader election failed due to %v, going to kick out from election", err)
 			km.notAMember()
 			return errors.New(fmt.Sprintf("Leader election failed due to %v, going to kick out from election", err))
 		}
 
 	} else {
-		km.currLeader = currLeader
-		return km.lead()
+		return km.lead(start, value, leader)
 	}
 	return nil
 }
 
+// followLeader follows whoever holds the leader key, without campaigning for it
+func (km *Kingsmoot) followLeader(ctx context.Context) error {
+	value, token, err := km.ds.Get(ctx, km.conf.Name)
+	leader := decodeLeaderRecord(value, token)
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if km.role == Dead {
+		return nil
+	}
+	km.observeDs(err)
+	if err != nil && err.(Error).Code() != KeyNotFound {
+		km.log().Infof("Failed to get leader of %v due to %v", km.conf.Name, err)
+		return err
+	}
+	if km.role == Leader {
+		return nil
+	}
+	if km.role == Follower && leader.Endpoint == km.currLeader && leader.Term == km.term {
+		return nil
+	}
+	return km.follow(leader)
+}
+
+// observeLeader looks up the leader key and tells the Observer if the leader changed
+func (km *Kingsmoot) observeLeader(ctx context.Context) error {
+	value, token, err := km.ds.Get(ctx, km.conf.Name)
+	leader := decodeLeaderRecord(value, token)
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if km.role == Dead {
+		return nil
+	}
+	km.observeDs(err)
+	if err != nil && err.(Error).Code() != KeyNotFound {
+		km.log().Infof("Failed to get leader of %v due to %v", km.conf.Name, err)
+		return err
+	}
+	if leader.Endpoint == km.currLeader && leader.Term == km.term {
+		return nil
+	}
+	km.currLeader, km.term, km.currRecord = leader.Endpoint, leader.Term, leader
+	km.updateLogger()
+	km.publish(LeaderChanged, nil)
+	o := km.o
+	if leader.Endpoint == "" {
+		km.log().Infof("Leader of %v is dead", km.conf.Name)
+		km.calls.push(o.OnLeaderDeath)
+	} else {
+		km.log().Infof("%v Elected as leader of %v", leader.Endpoint, km.conf.Name)
+		km.calls.push(func() { o.OnLeaderElect(leader.Endpoint) })
+	}
+	return nil
+}
+
+// KeyChangeListener wakes up loop. Changes are only a trigger to look at the
+// election again, so a change arriving while one is pending is dropped rather than
+// blocking the datastore.
 type KeyChangeListener struct {
 	changeCh chan *Change
 	errCh    chan error
 }
 
 func (l *KeyChangeListener) Notify(change *Change) {
-	l.changeCh <- change
+	select {
+	case l.changeCh <- change:
+	default:
+	}
 }
 
 func (l *KeyChangeListener) Bye(err error) {
-	l.errCh <- err
-}
-
-func (km *Kingsmoot) notAMember() {
-	err := km.c.UpdateMembership(MemberShip{Role: NotAMember})
-	if err != nil {
-		Fatal.Fatalf("Failed to update membership of %v due %v", km.c, err)
+	select {
+	case l.errCh <- err:
+	default:
 	}
-	km.setRole(NotAMember)
-	km.currLeader = ""
 }
 
-func (km *Kingsmoot) lead() error {
-	Info.Printf("%v Elected as leader of %v", km.c, km.conf.Name)
-	err := km.c.UpdateMembership(MemberShip{Role: Leader})
+func (km *Kingsmoot) refreshTTL(ctx context.Context) {
+	km.mu.Lock()
+	value := km.value
+	km.mu.Unlock()
+	start := km.conf.clock().Now()
+	err := km.ds.RefreshTTL(ctx, km.conf.Name, value, km.conf.MasterDownAfter)
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if km.role != Leader {
+		// Lease expired, stepped down or Exit was called while refreshing
+		return
+	}
+	km.observeDs(err)
+	if err != nil && km.steppingDown == value {
+		// The key is being deleted by StepDown, which moves the candidate to Follower
+		return
+	}
 	if err != nil {
-		Info.Printf("%v Failed to start as leader due to %v, going to kick out from election", km.c, err)
+		km.publish(RefreshFailed, err)
+		km.log().Infof("%v is no more the leader due to %v, going to kick out from election", km.c, err)
 		km.notAMember()
-		return errors.New(fmt.Sprintf("%v Failed to start as leader due to %v, going to kick out from election", km.c, err))
+		return
 	}
-	km.setRole(Leader)
-	return nil
+	km.extendLease(start)
 }
 
-func (km *Kingsmoot) follow() error {
-	Info.Printf("%v Elected as follower of %v", km.c, km.currLeader)
-	err := km.c.UpdateMembership(MemberShip{Role: Follower, Leader: km.currLeader})
-	if err != nil {
-		Info.Printf("%v Failed to start as follower due to %v, going to kick out from election", km.c, err)
+// extendLease moves the local lease deadline to TTL minus the safety margin after start,
+// the time the successful write of the leader key was sent. If the deadline passes before
+// the next successful refresh, the candidate is kicked out of the election even if the
+// refresh is still blocked on the datastore.
+func (km *Kingsmoot) extendLease(start time.Time) {
+	km.stopLease()
+	km.refreshedAt = start
+	deadline := start.Add(km.conf.MasterDownAfter - km.conf.leaseSafetyMargin())
+	var timer Timer
+	timer = km.conf.clock().AfterFunc(deadline.Sub(km.conf.clock().Now()), func() {
+		km.mu.Lock()
+		defer km.mu.Unlock()
+		if km.leaseTimer != timer || km.role != Leader {
+			return
+		}
+		km.log().Infof("%v could not refresh leadership of %v before lease deadline %v, going to kick out from election", km.c, km.conf.Name, deadline)
 		km.notAMember()
-		return errors.New(fmt.Sprintf("%v Failed to start as follower due to %v, going to kick out from election", km.c, err))
-	}
-	km.setRole(Follower)
-	return nil
+	})
+	km.leaseTimer = timer
 }
 
-func (km *Kingsmoot) refreshTTL() {
-	err := km.ds.RefreshTTL(km.conf.Name, km.endpoint, km.conf.MasterDownAfter)
-	if err != nil {
-		Info.Printf("%v is no more the leader due to %v, going to kick out from election", km.c, err)
-		km.notAMember()
-		return
+func (km *Kingsmoot) stopLease() {
+	if km.leaseTimer != nil {
+		km.leaseTimer.Stop()
+		km.leaseTimer = nil
 	}
-	km.setRole(Leader)
 }
 
 func (km *Kingsmoot) registerListener() *KeyChangeListener {
 	l := &KeyChangeListener{changeCh: make(chan *Change, 1), errCh: make(chan error, 1)}
-	km.ds.Watch(km.conf.Name, l)
+	km.watch(l)
 	return l
 }
+
+// watch sets up the watch of the leader key, a failure to do so is handled like the watch
+// ending, by trying again later
+func (km *Kingsmoot) watch(l *KeyChangeListener) {
+	if err := km.ds.Watch(km.ctx, km.conf.Name, l); err != nil {
+		l.Bye(err)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"golang.org/x/net/context"
 	"kingsmoot"
 	"os"
 	"os/exec"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -57,23 +60,32 @@ func assertNotNil(t *testing.T, err error, msg string) {
 }
 
 type MyCandidate struct {
-	roleCh   chan kingsmoot.Role
-	endpoint string
-	leader   string
+	roleCh     chan kingsmoot.Role
+	endpoint   string
+	mu         sync.Mutex //Protects memberShip
+	memberShip kingsmoot.MemberShip
 }
 
 func (c *MyCandidate) UpdateMembership(memberShip kingsmoot.MemberShip) error {
-	c.leader = memberShip.Leader
+	c.mu.Lock()
+	c.memberShip = memberShip
+	c.mu.Unlock()
 	c.roleCh <- memberShip.Role
 	return nil
 }
 
+func (c *MyCandidate) term() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.memberShip.Term
+}
+
 func (c *MyCandidate) String() string {
 	return c.endpoint
 }
 
 func CreateCandidate(endpoint string) *MyCandidate {
-	return &MyCandidate{roleCh: make(chan kingsmoot.Role, 1), endpoint: endpoint}
+	return &MyCandidate{roleCh: make(chan kingsmoot.Role, 16), endpoint: endpoint}
 }
 
 func TestJoinAsCandidate(t *testing.T) {
@@ -124,6 +136,512 @@ func TestJoinAsCandidate(t *testing.T) {
 	assertNotNil(t, err, "10:Should have timed out and no notification should have come")
 }
 
+// stallingDataStore blocks RefreshTTL while stalled, like a datastore behind a partition
+type stallingDataStore struct {
+	kingsmoot.DataStore
+	stalled chan bool
+}
+
+func (s *stallingDataStore) RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	<-s.stalled
+	return s.DataStore.RefreshTTL(ctx, key, value, ttl)
+}
+
+func TestLeaseDeadline(t *testing.T) {
+	stalled := make(chan bool)
+	kingsmoot.Register("stalling", func(ctx context.Context, conf *kingsmoot.Config) (kingsmoot.DataStore, error) {
+		ds, err := kingsmoot.NewMemoryDataStore(ctx, conf)
+		return &stallingDataStore{DataStore: ds, stalled: stalled}, err
+	})
+	conf := testMemoryConf(t.Name())
+	conf.DataStoreType = "stalling"
+	conf.LeaseSafetyMargin = 200 * time.Millisecond
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	start := time.Now()
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	defer close(stalled)
+	state, err := readState(c1.roleCh, 20*time.Millisecond)
+	assertNil(t, err, "3:Failed to get notification")
+	if state != kingsmoot.Leader {
+		t.Fatalf("%v should have been leader", c1)
+	}
+	state, err = readState(c1.roleCh, 2*time.Second)
+	assertNil(t, err, "4:Leader should have stepped down while refresh is stalled")
+	if state != kingsmoot.NotAMember {
+		t.Fatalf("%v should have been NotAMember, Got %v", c1, state)
+	}
+	if elapsed := time.Since(start); elapsed >= conf.MasterDownAfter {
+		t.Fatalf("Leader should have stepped down before its lease deadline, took %v", elapsed)
+	}
+}
+
+func TestStepDown(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	conf.StepDownCooldown = 5 * time.Second
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2), "5:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "6")
+	err = km2.StepDown(context.Background())
+	assertNotNil(t, err, "7:Follower should not be able to step down")
+	err = km1.StepDown(context.Background())
+	assertNil(t, err, "8:Failed to step down")
+	awaitState(t, c1.roleCh, kingsmoot.Follower, 20*time.Millisecond, "9")
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 100*time.Millisecond, "10")
+	leader, err := km1.Leader()
+	assertNil(t, err, "11:Failed to get leader")
+	if leader != c2.endpoint {
+		t.Fatalf("Expected leader %v Got %v", c2.endpoint, leader)
+	}
+}
+
+// slowDelDataStore returns from CompareAndDel a while after the key is gone, for the
+// watch of the candidate to see the delete first
+type slowDelDataStore struct {
+	kingsmoot.DataStore
+}
+
+func (ds slowDelDataStore) CompareAndDel(ctx context.Context, key string, prevValue string) error {
+	err := ds.DataStore.CompareAndDel(ctx, key, prevValue)
+	<-time.After(100 * time.Millisecond)
+	return err
+}
+
+func TestStepDownSlowDelete(t *testing.T) {
+	kingsmoot.Register(t.Name(), func(ctx context.Context, conf *kingsmoot.Config) (kingsmoot.DataStore, error) {
+		ds, err := kingsmoot.NewMemoryDataStore(ctx, conf)
+		return slowDelDataStore{ds}, err
+	})
+	conf := testMemoryConf(t.Name())
+	conf.DataStoreType = t.Name()
+	conf.StepDownCooldown = 10 * time.Second
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	assertNil(t, km1.StepDown(context.Background()), "4:Failed to step down")
+	awaitState(t, c1.roleCh, kingsmoot.Follower, 20*time.Millisecond, "5")
+	select {
+	case state := <-c1.roleCh:
+		t.Fatalf("6:Candidate should have stayed Follower while cooling down, Got %v", state)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := km1.Leader(); err == nil || err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		t.Fatalf("7:Leader key should have stayed deleted Got %v", err)
+	}
+}
+
+func TestTransferTo(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	var cs []*MyCandidate
+	var kms []*kingsmoot.Kingsmoot
+	for i := 1; i <= 3; i++ {
+		c := CreateCandidate(fmt.Sprintf("akem%v:6379", i))
+		km, err := kingsmoot.NewFromConf(conf)
+		assertNil(t, err, "1:Failed to create kingsmoot")
+		assertNil(t, km.Join(c.endpoint, c), "2:Failed to join leader election")
+		defer km.Exit()
+		cs, kms = append(cs, c), append(kms, km)
+	}
+	awaitState(t, cs[0].roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	err := kms[0].TransferTo(cs[0].endpoint)
+	assertNotNil(t, err, "4:Should not be able to transfer to itself")
+	err = kms[0].TransferTo(cs[2].endpoint)
+	assertNil(t, err, "5:Failed to transfer leadership")
+	awaitState(t, cs[2].roleCh, kingsmoot.Leader, 100*time.Millisecond, "6")
+	leader, err := kms[1].Leader()
+	assertNil(t, err, "7:Failed to get leader")
+	if leader != cs[2].endpoint {
+		t.Fatalf("Expected leader %v Got %v", cs[2].endpoint, leader)
+	}
+	for _, c := range cs[:2] {
+		for state, err := readState(c.roleCh, 100*time.Millisecond); err == nil; state, err = readState(c.roleCh, 100*time.Millisecond) {
+			if state != kingsmoot.Follower {
+				t.Fatalf("%v should have stayed follower, Got %v", c, state)
+			}
+		}
+	}
+}
+
+func TestEvict(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	var cs []*MyCandidate
+	var kms []*kingsmoot.Kingsmoot
+	for i := 1; i <= 2; i++ {
+		c := CreateCandidate(fmt.Sprintf("akem%v:6379", i))
+		km, err := kingsmoot.NewFromConf(conf)
+		assertNil(t, err, "1:Failed to create kingsmoot")
+		assertNil(t, km.Join(c.endpoint, c), "2:Failed to join leader election")
+		defer km.Exit()
+		cs, kms = append(cs, c), append(kms, km)
+	}
+	awaitState(t, cs[0].roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	record, err := kms[1].LeaderRecord()
+	assertNil(t, err, "4:Failed to get leader")
+	err = kms[1].Evict(cs[1].endpoint)
+	if err == nil || err.(kingsmoot.Error).Code() != kingsmoot.CompareFailed {
+		t.Fatalf("5:Evicting a follower should fail with CompareFailed, Got %v", err)
+	}
+	if same, err := kms[1].LeaderRecord(); err != nil || same.Endpoint != record.Endpoint || same.Term != record.Term {
+		t.Fatalf("5:Failed evict should have left the leader key alone, Got %+v %v", same, err)
+	}
+	assertNil(t, kms[1].Evict(cs[0].endpoint), "6:Failed to evict leader")
+	timeoutCh := time.After(conf.MasterDownAfter)
+	for state := kingsmoot.Leader; state == kingsmoot.Leader; {
+		select {
+		case state = <-cs[0].roleCh:
+		case <-timeoutCh:
+			t.Fatalf("7:%v should have lost leadership within %v", cs[0], conf.MasterDownAfter)
+		}
+	}
+	<-time.After(100 * time.Millisecond)
+	next, err := kms[1].LeaderRecord()
+	assertNil(t, err, "8:Failed to get leader")
+	if next.Term <= record.Term {
+		t.Fatalf("9:Expected a new term after %v Got %v", record.Term, next.Term)
+	}
+}
+
+func TestWatchLeader(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	observer, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	defer observer.Exit()
+	f := &MyObserver{leaderCh: make(chan string, 16)}
+	assertNil(t, observer.WatchLeader(context.Background(), func(leader kingsmoot.LeaderRecord) { f.leaderCh <- leader.Endpoint }), "2:Failed to watch leader")
+	awaitLeader(t, f, "", 20*time.Millisecond, "3")
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "5:Failed to join leader election")
+	awaitLeader(t, f, c1.endpoint, 100*time.Millisecond, "6")
+	km1.Exit()
+	awaitLeader(t, f, "", 100*time.Millisecond, "7")
+}
+
+func TestJoinContext(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConfContext(context.Background(), conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	defer km1.Exit()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = km1.JoinContext(ctx, c1.endpoint, c1)
+	assertNotNil(t, err, "2:Join should have failed as context is cancelled")
+	leader, err := km1.LeaderContext(ctx)
+	assertNotNil(t, err, "3:Leader should have failed as context is cancelled")
+	if leader != "" {
+		t.Fatalf("Expected no leader Got %v", leader)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConfContext(ctx, conf)
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	assertNil(t, km2.JoinContext(ctx, c2.endpoint, c2), "5:Failed to join leader election")
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 20*time.Millisecond, "6")
+	assertNil(t, km2.ExitContext(ctx), "7:Failed to exit")
+	_, err = km1.LeaderContext(context.Background())
+	assertNotNil(t, err, "8:Leader key should have been released on exit")
+}
+
+// MyObserver reports the leader it is told about, or "" on leader death
+type MyObserver struct {
+	leaderCh chan string
+}
+
+func (f *MyObserver) OnLeaderElect(leader string) {
+	f.leaderCh <- leader
+}
+
+func (f *MyObserver) OnLeaderDeath() {
+	f.leaderCh <- ""
+}
+
+func awaitLeader(t *testing.T, f *MyObserver, expected string, timeout time.Duration, step string) {
+	select {
+	case leader := <-f.leaderCh:
+		if leader != expected {
+			t.Fatalf("%v:Expected leader [%v] Got [%v]", step, expected, leader)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("%v:Observer was not told about leader [%v] within %v", step, expected, timeout)
+	}
+}
+
+// heldPutDataStore holds PutIfAbsent of key up till released, and then writes regardless
+// of ctx
+type heldPutDataStore struct {
+	kingsmoot.DataStore
+	key     string
+	entered chan bool
+	release chan bool
+}
+
+func (ds heldPutDataStore) PutIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (string, uint64, error) {
+	if key != ds.key {
+		return ds.DataStore.PutIfAbsent(ctx, key, value, ttl)
+	}
+	ds.entered <- true
+	<-ds.release
+	return ds.DataStore.PutIfAbsent(context.Background(), key, value, ttl)
+}
+
+func TestExitWhileCampaigning(t *testing.T) {
+	held := heldPutDataStore{key: "akem", entered: make(chan bool, 1), release: make(chan bool)}
+	kingsmoot.Register(t.Name(), func(ctx context.Context, conf *kingsmoot.Config) (kingsmoot.DataStore, error) {
+		ds, err := kingsmoot.NewMemoryDataStore(ctx, conf)
+		held.DataStore = ds
+		return held, err
+	})
+	conf := testMemoryConf(t.Name())
+	conf.DataStoreType = t.Name()
+	conf.MasterDownAfter = time.Minute
+	s, err := kingsmoot.NewSession(conf)
+	assertNil(t, err, "1:Failed to create session")
+	defer s.Close()
+	c1 := CreateCandidate("akem1:6379")
+	km1 := s.Election("akem")
+	joined := make(chan error, 1)
+	go func() { joined <- km1.Join(c1.endpoint, c1) }()
+	<-held.entered
+	km1.Exit()
+	close(held.release)
+	assertNil(t, <-joined, "2:Join should have returned quietly after Exit")
+	_, err = s.Election("akem").Leader()
+	if err == nil || err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		t.Fatalf("3:Key won after Exit should have been deleted Got %v", err)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	f := &MyObserver{leaderCh: make(chan string, 16)}
+	observer, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, observer.Observe(f), "2:Failed to observe")
+	defer observer.Exit()
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "3:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "4:Failed to join leader election")
+	defer km1.Exit()
+	awaitLeader(t, f, c1.endpoint, 100*time.Millisecond, "5")
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "6:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2), "7:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "8")
+	km1.Exit()
+	select {
+	case leader := <-f.leaderCh:
+		if leader != "" && leader != c2.endpoint {
+			t.Fatalf("9:Expected leader death or %v, Got [%v]", c2.endpoint, leader)
+		}
+		if leader == "" {
+			awaitLeader(t, f, c2.endpoint, 100*time.Millisecond, "10")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("9:Observer was not told about the exit of the leader")
+	}
+	assertNotNil(t, observer.Join("akem3:6379", CreateCandidate("akem3:6379")), "11:Observer should not be able to join")
+	observer.Exit()
+	leader, err := km2.Leader()
+	assertNil(t, err, "12:Exit of the observer should not touch the leader")
+	if leader != c2.endpoint {
+		t.Fatalf("13:Expected leader %v Got %v", c2.endpoint, leader)
+	}
+}
+
+// lastRoleCandidate remembers only the latest membership, so it never blocks Kingsmoot
+type lastRoleCandidate struct {
+	endpoint   string
+	mu         sync.Mutex //Protects memberShip
+	memberShip kingsmoot.MemberShip
+}
+
+func (c *lastRoleCandidate) UpdateMembership(memberShip kingsmoot.MemberShip) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memberShip = memberShip
+	return nil
+}
+
+func (c *lastRoleCandidate) String() string {
+	return c.endpoint
+}
+
+func (c *lastRoleCandidate) role() kingsmoot.Role {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.memberShip.Role
+}
+
+// reentrantCandidate looks at the Status of its Kingsmoot on every membership
+type reentrantCandidate struct {
+	endpoint string
+	km       *kingsmoot.Kingsmoot
+	roleCh   chan string
+}
+
+func (c *reentrantCandidate) UpdateMembership(memberShip kingsmoot.MemberShip) error {
+	c.roleCh <- c.km.Status().Role
+	return nil
+}
+
+func (c *reentrantCandidate) String() string {
+	return c.endpoint
+}
+
+func TestCandidateCallsBack(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	defer km1.Exit()
+	c1 := &reentrantCandidate{endpoint: "akem1:6379", km: km1, roleCh: make(chan string, 16)}
+	joined := make(chan error, 1)
+	go func() { joined <- km1.Join(c1.endpoint, c1) }()
+	select {
+	case err := <-joined:
+		assertNil(t, err, "2:Failed to join leader election")
+	case <-time.After(time.Second):
+		t.Fatal("3:Join should not have been held up by the Candidate")
+	}
+	select {
+	case role := <-c1.roleCh:
+		if role != kingsmoot.Leader.String() {
+			t.Fatalf("4:Expected Leader Got %v", role)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("5:Candidate should have been told it leads")
+	}
+}
+
+// refusingCandidate fails to take up Leader, like a node whose service fails to start
+type refusingCandidate struct {
+	*MyCandidate
+}
+
+func (c refusingCandidate) UpdateMembership(memberShip kingsmoot.MemberShip) error {
+	c.MyCandidate.UpdateMembership(memberShip)
+	if memberShip.Role == kingsmoot.Leader {
+		return errors.New("Failed to start")
+	}
+	return nil
+}
+
+func TestRefuseLeader(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	conf.MasterDownAfter = time.Minute
+	c1 := refusingCandidate{CreateCandidate("akem1:6379")}
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	awaitState(t, c1.roleCh, kingsmoot.NotAMember, 20*time.Millisecond, "4")
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "5:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2), "6:Failed to join leader election")
+	defer km2.Exit()
+	// The leader key was given up, so c2 need not wait for it to expire
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 100*time.Millisecond, "7")
+	awaitState(t, c1.roleCh, kingsmoot.Follower, 100*time.Millisecond, "8")
+	leader, err := km1.Leader()
+	assertNil(t, err, "9:Failed to get leader")
+	if leader != c2.endpoint {
+		t.Fatalf("10:Expected leader %v Got %v", c2.endpoint, leader)
+	}
+}
+
+func TestConcurrentElection(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	conf.MasterDownAfter = 200 * time.Millisecond
+	var cs []*lastRoleCandidate
+	var kms []*kingsmoot.Kingsmoot
+	for i := 1; i <= 5; i++ {
+		c := &lastRoleCandidate{endpoint: fmt.Sprintf("akem%v:6379", i)}
+		km, err := kingsmoot.NewFromConf(conf)
+		assertNil(t, err, "1:Failed to create kingsmoot")
+		cs, kms = append(cs, c), append(kms, km)
+	}
+	var wg sync.WaitGroup
+	for i, km := range kms {
+		wg.Add(1)
+		go func(km *kingsmoot.Kingsmoot, c *lastRoleCandidate) {
+			defer wg.Done()
+			km.Join(c.endpoint, c)
+			for j := 0; j < 20; j++ {
+				km.StepDown(context.Background())
+				km.Leader()
+				time.Sleep(5 * time.Millisecond)
+			}
+		}(km, cs[i])
+	}
+	wg.Wait()
+	time.Sleep(conf.MasterDownAfter)
+	leader, err := kms[0].Leader()
+	assertNil(t, err, "2:There should have been a leader")
+	leaders := 0
+	for _, c := range cs {
+		if c.role() == kingsmoot.Leader {
+			leaders++
+			if c.endpoint != leader {
+				t.Fatalf("%v thinks it is leader, but leader is %v", c, leader)
+			}
+		}
+	}
+	if leaders != 1 {
+		t.Fatalf("Expected exactly one leader, Got %v", leaders)
+	}
+	var exits sync.WaitGroup
+	for _, km := range kms {
+		exits.Add(1)
+		go func(km *kingsmoot.Kingsmoot) {
+			defer exits.Done()
+			km.Exit()
+		}(km)
+	}
+	exits.Wait()
+	for i, km := range kms {
+		err = km.StepDown(context.Background())
+		assertNotNil(t, err, "3:Dead kingsmoot should not step down")
+		err = km.Join(cs[i].endpoint, cs[i])
+		assertNotNil(t, err, "4:Dead kingsmoot should not join")
+	}
+}
+
+// awaitState reads role changes till the expected one turns up
+func awaitState(t *testing.T, c chan kingsmoot.Role, expected kingsmoot.Role, timeout time.Duration, step string) {
+	timeoutCh := time.After(timeout)
+	for {
+		select {
+		case r := <-c:
+			if r == expected {
+				return
+			}
+		case <-timeoutCh:
+			t.Fatalf("%v:Should have become %v within %v", step, expected, timeout)
+		}
+	}
+}
+
 func readState(c chan kingsmoot.Role, timeout time.Duration) (kingsmoot.Role, error) {
 	timeoutCh := time.After(timeout)
 	select {
@@ -133,3 +651,47 @@ func readState(c chan kingsmoot.Role, timeout time.Duration) (kingsmoot.Role, er
 		return 0, errors.New("Timeout")
 	}
 }
+
+// healthCandidate fails its health check while unhealthy is set
+type healthCandidate struct {
+	*MyCandidate
+	unhealthy int32
+}
+
+func (c *healthCandidate) CheckHealth(ctx context.Context) error {
+	if atomic.LoadInt32(&c.unhealthy) == 1 {
+		return errors.New("Downstream unreachable")
+	}
+	return nil
+}
+
+func TestUnhealthyLeaderStepsDown(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	conf.UnhealthyThreshold = 2
+	c1 := &healthCandidate{MyCandidate: CreateCandidate("akem1:6379")}
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	c2 := &healthCandidate{MyCandidate: CreateCandidate("akem2:6379")}
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2), "5:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "6")
+
+	atomic.StoreInt32(&c1.unhealthy, 1)
+	awaitState(t, c1.roleCh, kingsmoot.Follower, 2*time.Second, "7")
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 100*time.Millisecond, "8")
+
+	// Unhealthy follower does not take over from a leader which went away
+	km2.Exit()
+	for state, err := readState(c1.roleCh, 1500*time.Millisecond); err == nil; state, err = readState(c1.roleCh, 1500*time.Millisecond) {
+		if state == kingsmoot.Leader {
+			t.Fatalf("9:Unhealthy %v should not have become leader", c1)
+		}
+	}
+	atomic.StoreInt32(&c1.unhealthy, 0)
+	awaitState(t, c1.roleCh, kingsmoot.Leader, time.Second, "10")
+}
@@ -0,0 +1,146 @@
+package kingsmoot
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// lease is a key written with PutIfAbsent and a TTL of MasterDownAfter, and renewed in the
+// background till it is ended, as the leader key is. It backs Lock and Semaphore.
+type lease struct {
+	conf  *Config
+	ds    DataStore
+	key   string
+	value string
+	done  chan struct{}
+	stop  context.CancelFunc //Stops the renewal
+	once  sync.Once
+	mu    sync.Mutex //Protects timer
+	timer Timer      //Ends the lease at its deadline
+}
+
+var leaseIDs uint64
+
+// newLeaseValue is unique to a lease across processes and hosts
+func newLeaseValue() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%v:%v:%v", hostname, os.Getpid(), atomic.AddUint64(&leaseIDs, 1))
+}
+
+// acquireLease writes key with value and returns the lease along with the fencing token of
+// the key. It fails with KeyExists if the key is already there.
+func acquireLease(ctx context.Context, conf *Config, ds DataStore, key string, value string) (*lease, uint64, error) {
+	start := conf.clock().Now()
+	_, token, err := ds.PutIfAbsent(ctx, key, value, conf.MasterDownAfter)
+	if err != nil {
+		return nil, 0, err
+	}
+	ls := &lease{conf: conf, ds: ds, key: key, value: value, done: make(chan struct{})}
+	var renewCtx context.Context
+	renewCtx, ls.stop = context.WithCancel(context.Background())
+	ls.extend(start)
+	go ls.renew(renewCtx)
+	return ls, token, nil
+}
+
+// renew refreshes the TTL of the key till ctx is done. The lease ends once the key is gone.
+func (ls *lease) renew(ctx context.Context) {
+	ttl := ls.conf.MasterDownAfter
+	for {
+		select {
+		case <-ls.conf.clock().After(ttl / 3):
+		case <-ctx.Done():
+			return
+		}
+		start := ls.conf.clock().Now()
+		err := ls.ds.RefreshTTL(ctx, ls.key, ls.value, ttl)
+		if err == nil {
+			ls.extend(start)
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		switch err.(Error).Code() {
+		case KeyNotFound, CompareFailed:
+			if ls.end() {
+				ls.conf.logger().With(Fields{"service": ls.conf.Name}).Warnf("Lost %v due to %v", ls.key, err)
+			}
+			return
+		}
+	}
+}
+
+// extend moves the deadline of the lease to TTL minus LeaseSafetyMargin after start, the
+// time the successful write of the key was sent. The lease ends at the deadline unless it
+// is extended again, even if a refresh is still blocked on the datastore.
+func (ls *lease) extend(start time.Time) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.timer != nil {
+		ls.timer.Stop()
+	}
+	deadline := start.Add(ls.conf.MasterDownAfter - ls.conf.leaseSafetyMargin())
+	var timer Timer
+	timer = ls.conf.clock().AfterFunc(deadline.Sub(ls.conf.clock().Now()), func() {
+		ls.mu.Lock()
+		current := ls.timer == timer
+		ls.mu.Unlock()
+		if current && ls.end() {
+			ls.conf.logger().With(Fields{"service": ls.conf.Name}).Warnf("Lost %v as it could not be refreshed before deadline %v", ls.key, deadline)
+		}
+	})
+	ls.timer = timer
+}
+
+// end stops the renewal and closes done, and tells if the lease was live till then
+func (ls *lease) end() bool {
+	ended := false
+	ls.once.Do(func() {
+		ended = true
+		ls.stop()
+		ls.mu.Lock()
+		if ls.timer != nil {
+			ls.timer.Stop()
+		}
+		ls.mu.Unlock()
+		close(ls.done)
+	})
+	return ended
+}
+
+// ended tells if the lease was released or lost
+func (ls *lease) ended() bool {
+	select {
+	case <-ls.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// release ends the lease and deletes the key if it still holds the value of the lease
+func (ls *lease) release(ctx context.Context) error {
+	ls.end()
+	err := ls.ds.CompareAndDel(ctx, ls.key, ls.value)
+	if err != nil {
+		switch err.(Error).Code() {
+		case CompareFailed, KeyNotFound:
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// closedCh is done of no lease at all
+var closedCh = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
@@ -0,0 +1,127 @@
+package kingsmoot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Lock is a mutex on the key Config.Name in the datastore, for short lived mutual exclusion
+// where a long running leader is not called for. The key is written with PutIfAbsent and a
+// TTL of MasterDownAfter, and renewed in the background while the lock is held, as the
+// leader key is. A Lock is held by one goroutine at a time and can be locked again once
+// unlocked.
+type Lock struct {
+	conf *Config
+	ds   DataStore
+	mu   sync.Mutex //Protects ls, held while acquiring
+	ls   *lease     //Lease of the key since the lock was last acquired
+}
+
+func NewLock(conf *Config) (*Lock, error) {
+	return NewLockContext(context.Background(), conf)
+}
+
+// NewLockContext is NewLock with ctx bounding the connection to the datastore
+func NewLockContext(ctx context.Context, conf *Config) (*Lock, error) {
+	ds, err := CreateDatastoreContext(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return newLock(conf, ds), nil
+}
+
+func newLock(conf *Config, ds DataStore) *Lock {
+	return &Lock{conf: conf, ds: ds}
+}
+
+// Lock blocks till the lock is acquired or ctx is done, and returns the fencing token of
+// the key. The token grows from one holder of the lock to the next.
+func (l *Lock) Lock(ctx context.Context) (uint64, error) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	listener := &KeyChangeListener{changeCh: make(chan *Change, 1), errCh: make(chan error, 1)}
+	if err := l.ds.Watch(watchCtx, l.conf.Name, listener); err != nil {
+		return 0, err
+	}
+	for {
+		token, err := l.TryLock(ctx)
+		if err == nil {
+			return token, nil
+		}
+		if e, ok := err.(Error); !ok || e.Code() != KeyExists {
+			return 0, err
+		}
+		select {
+		case <-listener.changeCh:
+		case <-listener.errCh:
+		case <-l.conf.clock().After(l.conf.MasterDownAfter / 2):
+		case <-ctx.Done():
+			return 0, ctxError("Lock", ctx)
+		}
+	}
+}
+
+// ctxError is the error of an operation given up on as ctx is done
+func ctxError(op string, ctx context.Context) Error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &OpError{code: Timeout, op: op, cause: ctx.Err()}
+	}
+	return &OpError{code: DataStoreError, op: op, cause: ctx.Err()}
+}
+
+// TryLock acquires the lock if it is free and returns the fencing token of the key. It
+// fails with KeyExists if the lock is held by someone else.
+func (l *Lock) TryLock(ctx context.Context) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ls != nil && !l.ls.ended() {
+		return 0, errors.New(fmt.Sprintf("Lock %v is already held", l.conf.Name))
+	}
+	ls, token, err := acquireLease(ctx, l.conf, l.ds, l.conf.Name, newLeaseValue())
+	if err != nil {
+		return 0, err
+	}
+	l.ls = ls
+	return token, nil
+}
+
+// Done is closed once the lock is no more held, be it on Unlock or on failing to renew it
+func (l *Lock) Done() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ls == nil {
+		return closedCh
+	}
+	return l.ls.done
+}
+
+func (l *Lock) Unlock() error {
+	return l.UnlockContext(context.Background())
+}
+
+// UnlockContext is Unlock with ctx bounding the deletion of the key
+func (l *Lock) UnlockContext(ctx context.Context) error {
+	l.mu.Lock()
+	ls := l.ls
+	l.ls = nil
+	l.mu.Unlock()
+	if ls == nil || ls.ended() {
+		return errors.New(fmt.Sprintf("Lock %v is not held", l.conf.Name))
+	}
+	return ls.release(ctx)
+}
+
+// Close unlocks the lock if it is held, and closes the connection to the datastore
+func (l *Lock) Close() error {
+	l.mu.Lock()
+	ls := l.ls
+	l.ls = nil
+	l.mu.Unlock()
+	if ls != nil {
+		ls.release(context.Background())
+	}
+	return l.ds.Close()
+}
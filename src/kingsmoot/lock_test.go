@@ -0,0 +1,121 @@
+package kingsmoot_test
+
+import (
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+// stuckRefreshDataStore never gets a refresh through, like a datastore which stopped
+// answering
+type stuckRefreshDataStore struct {
+	kingsmoot.DataStore
+}
+
+func (ds stuckRefreshDataStore) RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// stuckRefreshConf is the Config of a datastore with stuck refreshes on a FakeClock, with a
+// TTL of 30s and a lease deadline at 27s
+func stuckRefreshConf(t *testing.T) (*kingsmoot.Config, *kingsmoot.FakeClock) {
+	kingsmoot.Register(t.Name(), func(ctx context.Context, conf *kingsmoot.Config) (kingsmoot.DataStore, error) {
+		ds, err := kingsmoot.NewMemoryDataStore(ctx, conf)
+		return stuckRefreshDataStore{ds}, err
+	})
+	clock := kingsmoot.NewFakeClock(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	conf := testMemoryConf(t.Name())
+	conf.DataStoreType = t.Name()
+	conf.MasterDownAfter, conf.LeaseSafetyMargin = 30*time.Second, 3*time.Second
+	conf.Clock = clock
+	return conf, clock
+}
+
+// awaitDeadline checks that done is closed at the lease deadline of stuckRefreshConf, and
+// not before
+func awaitDeadline(t *testing.T, clock *kingsmoot.FakeClock, done <-chan struct{}) {
+	clock.Advance(26 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("Lease should have been held till its deadline")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Lease should have ended at its deadline, though the refresh was still blocked")
+	}
+}
+
+func TestLock(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	l1, err := kingsmoot.NewLock(conf)
+	assertNil(t, err, "1:Failed to create lock")
+	defer l1.Close()
+	l2, err := kingsmoot.NewLock(conf)
+	assertNil(t, err, "2:Failed to create lock")
+	defer l2.Close()
+
+	token1, err := l1.TryLock(context.Background())
+	assertNil(t, err, "3:Failed to lock")
+	_, err = l1.TryLock(context.Background())
+	assertNotNil(t, err, "4:Lock should not be reentrant")
+	_, err = l2.TryLock(context.Background())
+	assertNotNil(t, err, "5:Lock should have been held")
+	if err.(kingsmoot.Error).Code() != kingsmoot.KeyExists {
+		t.Fatalf("5:Expected %v Got %v", kingsmoot.KeyExists, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = l2.Lock(ctx)
+	assertNotNil(t, err, "6:Lock should have timed out")
+	if err.(kingsmoot.Error).Code() != kingsmoot.Timeout {
+		t.Fatalf("6:Expected %v Got %v", kingsmoot.Timeout, err)
+	}
+
+	type result struct {
+		token uint64
+		err   error
+	}
+	acquired := make(chan result, 1)
+	go func() {
+		token, err := l2.Lock(context.Background())
+		acquired <- result{token, err}
+	}()
+	// Held past its TTL, as it is renewed
+	select {
+	case r := <-acquired:
+		t.Fatalf("7:Lock should have been held past its TTL, Got %+v", r)
+	case <-time.After(2 * conf.MasterDownAfter):
+	}
+	done := l1.Done()
+	assertNil(t, l1.Unlock(), "8:Failed to unlock")
+	select {
+	case <-done:
+	default:
+		t.Fatal("9:Done should have been closed on Unlock")
+	}
+	assertNotNil(t, l1.Unlock(), "10:Unlock of a lock not held should fail")
+	select {
+	case r := <-acquired:
+		assertNil(t, r.err, "11:Failed to lock")
+		if r.token <= token1 {
+			t.Fatalf("12:Token %v should have been greater than %v", r.token, token1)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("11:Lock should have been acquired on Unlock")
+	}
+}
+
+func TestLockDeadline(t *testing.T) {
+	conf, clock := stuckRefreshConf(t)
+	l, err := kingsmoot.NewLock(conf)
+	assertNil(t, err, "1:Failed to create lock")
+	defer l.Close()
+	_, err = l.TryLock(context.Background())
+	assertNil(t, err, "2:Failed to lock")
+	awaitDeadline(t, clock, l.Done())
+}
@@ -1,10 +1,15 @@
 package kingsmoot
 
 import (
+	"fmt"
 	"io"
 	"log"
+	"sort"
+	"strings"
 )
 
+// Deprecated: set Config.Logger instead. Kingsmoots created after Init log through these,
+// unless Config.Logger is set.
 var (
 	Trace   *log.Logger
 	Info    *log.Logger
@@ -18,3 +23,99 @@ func Init(traceHandle io.Writer, infoHandle io.Writer, warningHandle io.Writer,
 	Warning = log.New(warningHandle, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
 	Fatal = log.New(errorHandle, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
 }
+
+// Fields are the structured context of a log line, e.g. service, endpoint, role and term
+type Fields map[string]interface{}
+
+func (f Fields) String() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%v=%v", k, f[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Logger is what Kingsmoot logs through. Implementations must be safe for concurrent use.
+type Logger interface {
+	// With returns a Logger which adds fields to every line, on top of its own
+	With(fields Fields) Logger
+	Tracef(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (l nopLogger) With(fields Fields) Logger               { return l }
+func (nopLogger) Tracef(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Warnf(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+// NopLogger drops everything, it is what Kingsmoot logs through by default
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type stdLogger struct {
+	trace, info, warning, err *log.Logger
+	fields                    Fields
+}
+
+// NewStdLogger logs each level to the given log.Logger, with the fields appended to the
+// line. A nil log.Logger drops its level.
+func NewStdLogger(trace *log.Logger, info *log.Logger, warning *log.Logger, err *log.Logger) Logger {
+	return &stdLogger{trace: trace, info: info, warning: warning, err: err}
+}
+
+func (l *stdLogger) With(fields Fields) Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &stdLogger{trace: l.trace, info: l.info, warning: l.warning, err: l.err, fields: merged}
+}
+
+func (l *stdLogger) output(to *log.Logger, format string, args []interface{}) {
+	if to == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if len(l.fields) != 0 {
+		msg = msg + " " + l.fields.String()
+	}
+	to.Output(3, msg)
+}
+
+func (l *stdLogger) Tracef(format string, args ...interface{}) {
+	l.output(l.trace, format, args)
+}
+
+func (l *stdLogger) Infof(format string, args ...interface{}) {
+	l.output(l.info, format, args)
+}
+
+func (l *stdLogger) Warnf(format string, args ...interface{}) {
+	l.output(l.warning, format, args)
+}
+
+func (l *stdLogger) Errorf(format string, args ...interface{}) {
+	l.output(l.err, format, args)
+}
+
+// defaultLogger logs through the package loggers if Init was called, nowhere otherwise
+func defaultLogger() Logger {
+	if Info == nil {
+		return NopLogger()
+	}
+	return NewStdLogger(Trace, Info, Warning, Fatal)
+}
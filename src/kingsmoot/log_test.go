@@ -0,0 +1,134 @@
+package kingsmoot_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingLogger keeps every line along with its fields
+type recordingLogger struct {
+	mu     *sync.Mutex
+	lines  *[]string
+	fields kingsmoot.Fields
+}
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{mu: &sync.Mutex{}, lines: &[]string{}}
+}
+
+func (l *recordingLogger) With(fields kingsmoot.Fields) kingsmoot.Logger {
+	merged := kingsmoot.Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &recordingLogger{mu: l.mu, lines: l.lines, fields: merged}
+}
+
+func (l *recordingLogger) record(level string, format string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.lines = append(*l.lines, fmt.Sprintf("%v:%v %v", level, fmt.Sprintf(format, args...), l.fields))
+}
+
+func (l *recordingLogger) Tracef(format string, args ...interface{}) { l.record("TRACE", format, args) }
+func (l *recordingLogger) Infof(format string, args ...interface{})  { l.record("INFO", format, args) }
+func (l *recordingLogger) Warnf(format string, args ...interface{})  { l.record("WARN", format, args) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) { l.record("ERROR", format, args) }
+
+func (l *recordingLogger) find(substrs ...string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range *l.lines {
+		found := true
+		for _, substr := range substrs {
+			found = found && strings.Contains(line, substr)
+		}
+		if found {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestLogger(t *testing.T) {
+	logger := newRecordingLogger()
+	conf := testMemoryConf(t.Name())
+	conf.Logger = logger
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	assertNil(t, km1.StepDown(context.Background()), "4:Failed to step down")
+	line := logger.find("INFO:", "stepped down", "service=akem", "endpoint=akem1:6379", "role=Leader", "term=")
+	if line == "" {
+		t.Fatalf("5:Step down should have been logged with the state of the leader, Got %v", *logger.lines)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := kingsmoot.NewStdLogger(nil, log.New(&buf, "INFO: ", 0), nil, nil)
+	logger.With(kingsmoot.Fields{"service": "akem", "term": 3}).Infof("Elected %v", "akem1:6379")
+	logger.Tracef("Dropped")
+	if buf.String() != "INFO: Elected akem1:6379 service=akem term=3\n" {
+		t.Fatalf("Unexpected line [%v]", buf.String())
+	}
+}
+
+// stubbornCandidate refuses every membership other than Leader
+type stubbornCandidate struct {
+	endpoint string
+}
+
+func (c *stubbornCandidate) UpdateMembership(memberShip kingsmoot.MemberShip) error {
+	if memberShip.Role == kingsmoot.Leader {
+		return nil
+	}
+	return errors.New("Refusing " + memberShip.Role.String())
+}
+
+func (c *stubbornCandidate) String() string {
+	return c.endpoint
+}
+
+func TestFailingCandidateDoesNotExit(t *testing.T) {
+	logger := newRecordingLogger()
+	conf := testMemoryConf(t.Name())
+	conf.Logger = logger
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	events := km1.Events()
+	assertNil(t, km1.Join("akem1:6379", &stubbornCandidate{endpoint: "akem1:6379"}), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitEvent(t, events, kingsmoot.RoleChanged, 20*time.Millisecond, "3")
+	assertNil(t, km1.StepDown(context.Background()), "4:Failed to step down")
+	// The candidate refuses to follow, and is kicked out of the election for it
+	e := awaitEvent(t, events, kingsmoot.RoleChanged, 20*time.Millisecond, "5")
+	if e.MemberShip.Role != kingsmoot.Follower {
+		t.Fatalf("Should have been follower, Got %v", e)
+	}
+	e = awaitEvent(t, events, kingsmoot.RoleChanged, 20*time.Millisecond, "6")
+	if e.MemberShip.Role != kingsmoot.NotAMember {
+		t.Fatalf("Should have been out of election, Got %v", e)
+	}
+	deadline := time.Now().Add(20 * time.Millisecond)
+	for logger.find("ERROR:", "Refusing NotAMember") == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("7:Failure to update membership should have been logged, Got %v", *logger.lines)
+		}
+		<-time.After(time.Millisecond)
+	}
+}
@@ -0,0 +1,155 @@
+package kingsmoot
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Member is a candidate which joined the election, leader or not. Every candidate keeps
+// its member key alive under the members prefix of the election till it exits, or till
+// MasterDownAfter after it is gone.
+type Member struct {
+	Endpoint string            `json:"endpoint"`
+	Hostname string            `json:"hostname,omitempty"`
+	Pid      int               `json:"pid,omitempty"`
+	Priority int               `json:"priority,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	JoinedAt time.Time         `json:"joinedAt"`
+}
+
+func (km *Kingsmoot) membersPrefix() string {
+	return km.conf.Name + ".members/"
+}
+
+// memberKey escapes endpoint, which may well be a URL, to a single level under the prefix
+func (km *Kingsmoot) memberKey(endpoint string) string {
+	return km.membersPrefix() + url.QueryEscape(endpoint)
+}
+
+func (km *Kingsmoot) decodeMember(key string, value string) Member {
+	var m Member
+	if json.Unmarshal([]byte(value), &m) != nil || m.Endpoint == "" {
+		endpoint, _ := url.QueryUnescape(strings.TrimPrefix(key, km.membersPrefix()))
+		m = Member{Endpoint: endpoint}
+	}
+	return m
+}
+
+// register writes the member key of the candidate, taking over any left behind by an
+// earlier run with the same endpoint
+func (km *Kingsmoot) register(ctx context.Context) error {
+	km.mu.Lock()
+	endpoint := km.endpoint
+	hostname, _ := os.Hostname()
+	b, _ := json.Marshal(Member{Endpoint: endpoint, Hostname: hostname, Pid: os.Getpid(), Priority: km.priority,
+		Labels: km.conf.Labels, JoinedAt: km.conf.clock().Now().UTC()})
+	km.member = string(b)
+	km.mu.Unlock()
+	key := km.memberKey(endpoint)
+	prevValue, _, err := km.ds.PutIfAbsent(ctx, key, string(b), km.conf.MasterDownAfter)
+	if err != nil && err.(Error).Code() == KeyExists {
+		if err = km.ds.CompareAndDel(ctx, key, prevValue); err == nil || err.(Error).Code() == KeyNotFound {
+			_, _, err = km.ds.PutIfAbsent(ctx, key, string(b), km.conf.MasterDownAfter)
+		}
+	}
+	return err
+}
+
+// refreshMember keeps the member key alive, and writes it again if it expired meanwhile
+func (km *Kingsmoot) refreshMember(ctx context.Context) {
+	km.mu.Lock()
+	key, member := km.memberKey(km.endpoint), km.member
+	km.mu.Unlock()
+	err := km.ds.RefreshTTL(ctx, key, member, km.conf.MasterDownAfter)
+	if err != nil && err.(Error).Code() == KeyNotFound {
+		_, _, err = km.ds.PutIfAbsent(ctx, key, member, km.conf.MasterDownAfter)
+	}
+	if err != nil {
+		km.log().Infof("Failed to refresh membership of %v due to %v", km.c, err)
+	}
+}
+
+// Members returns every candidate which joined the election and has not exited, sorted
+// by endpoint. The leader is among them, Leader tells which one it is.
+func (km *Kingsmoot) Members() ([]Member, error) {
+	return km.MembersContext(context.Background())
+}
+
+func (km *Kingsmoot) MembersContext(ctx context.Context) ([]Member, error) {
+	values, err := km.ds.List(ctx, km.membersPrefix())
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, 0, len(values))
+	for key, kv := range values {
+		members = append(members, km.decodeMember(key, kv.Value))
+	}
+	sort.Sort(byEndpoint(members))
+	return members, nil
+}
+
+type byEndpoint []Member
+
+func (m byEndpoint) Len() int           { return len(m) }
+func (m byEndpoint) Less(i, j int) bool { return m[i].Endpoint < m[j].Endpoint }
+func (m byEndpoint) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// WatchMembers calls f with the members of the election right away and then on every
+// change of them, till ctx is done or Exit. Calls are made one at a time from a goroutine
+// of Kingsmoot.
+func (km *Kingsmoot) WatchMembers(ctx context.Context, f func(members []Member)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-km.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	l := &KeyChangeListener{changeCh: make(chan *Change, 1), errCh: make(chan error, 1)}
+	if err := km.ds.WatchPrefix(ctx, km.membersPrefix(), l); err != nil {
+		cancel()
+		return err
+	}
+	members, err := km.MembersContext(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	go func() {
+		defer cancel()
+		f(members)
+		for {
+			select {
+			case <-km.conf.clock().After(km.conf.MasterDownAfter / 2):
+			case <-l.changeCh:
+			case <-ctx.Done():
+				return
+			case err := <-l.errCh:
+				km.log().Infof("Watch of members ended due to %v", err)
+				select {
+				case <-km.conf.clock().After(km.conf.MasterDownAfter):
+				case <-ctx.Done():
+					return
+				}
+				if err := km.ds.WatchPrefix(ctx, km.membersPrefix(), l); err != nil {
+					l.Bye(err)
+				}
+			}
+			next, err := km.MembersContext(ctx)
+			if err != nil || reflect.DeepEqual(next, members) {
+				continue
+			}
+			members = next
+			f(members)
+		}
+	}()
+	return nil
+}
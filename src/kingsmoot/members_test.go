@@ -0,0 +1,67 @@
+package kingsmoot_test
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+func endpoints(members []kingsmoot.Member) string {
+	var s string
+	for _, m := range members {
+		s += fmt.Sprintf("%v:%v,", m.Endpoint, m.Priority)
+	}
+	return s
+}
+
+func awaitMembers(t *testing.T, ch chan []kingsmoot.Member, expected string, timeout time.Duration, step string) {
+	timeoutCh := time.After(timeout)
+	for {
+		select {
+		case members := <-ch:
+			if endpoints(members) == expected {
+				return
+			}
+		case <-timeoutCh:
+			t.Fatalf("%v:Members should have been [%v] within %v", step, expected, timeout)
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	observer, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	defer observer.Exit()
+	membersCh := make(chan []kingsmoot.Member, 16)
+	assertNil(t, observer.WatchMembers(context.Background(), func(members []kingsmoot.Member) { membersCh <- members }), "2:Failed to watch members")
+	awaitMembers(t, membersCh, "", 20*time.Millisecond, "3")
+
+	var kms []*kingsmoot.Kingsmoot
+	for i := 1; i <= 3; i++ {
+		c := CreateCandidate(fmt.Sprintf("http://akem%v:6379/", i))
+		km, err := kingsmoot.NewFromConf(conf)
+		assertNil(t, err, "4:Failed to create kingsmoot")
+		assertNil(t, km.Join(c.endpoint, c, kingsmoot.WithPriority(i)), "5:Failed to join leader election")
+		defer km.Exit()
+		kms = append(kms, km)
+	}
+	awaitMembers(t, membersCh, "http://akem1:6379/:1,http://akem2:6379/:2,http://akem3:6379/:3,", 100*time.Millisecond, "6")
+	members, err := kms[0].Members()
+	assertNil(t, err, "7:Failed to get members")
+	if endpoints(members) != "http://akem1:6379/:1,http://akem2:6379/:2,http://akem3:6379/:3," || members[0].Pid == 0 {
+		t.Fatalf("8:Unexpected members %+v", members)
+	}
+
+	kms[1].Exit()
+	awaitMembers(t, membersCh, "http://akem1:6379/:1,http://akem3:6379/:3,", 100*time.Millisecond, "9")
+	// Membership outlives refreshes of the member key
+	<-time.After(2 * conf.MasterDownAfter)
+	members, err = observer.Members()
+	assertNil(t, err, "10:Failed to get members")
+	if endpoints(members) != "http://akem1:6379/:1,http://akem3:6379/:3," {
+		t.Fatalf("11:Unexpected members %+v", members)
+	}
+}
@@ -0,0 +1,361 @@
+package kingsmoot
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// MemoryDataStore is an in-process DataStore. All MemoryDataStores created with the same
+// Addresses share one keyspace, so several Kingsmoot instances in a process can elect a
+// leader among themselves without any external coordination service.
+type MemoryDataStore struct {
+	store   *memStore
+	mu      sync.Mutex
+	closed  bool
+	watches []*memWatch
+}
+
+type memEntry struct {
+	value   string
+	created uint64
+	expiry  Timer
+}
+
+type memStore struct {
+	clock         Clock
+	mu            sync.Mutex
+	index         uint64
+	entries       map[string]*memEntry
+	watches       map[string][]*memWatch
+	prefixWatches []*memWatch
+}
+
+var (
+	memStoresMu sync.Mutex
+	memStores   = make(map[string]*memStore)
+)
+
+// getMemStore returns the keyspace of addresses, whose keys expire by the clock of the
+// first MemoryDataStore created for it
+func getMemStore(addresses []string, clock Clock) *memStore {
+	name := strings.Join(addresses, ",")
+	memStoresMu.Lock()
+	defer memStoresMu.Unlock()
+	s, ok := memStores[name]
+	if !ok {
+		s = &memStore{clock: clock, entries: make(map[string]*memEntry), watches: make(map[string][]*memWatch)}
+		memStores[name] = s
+	}
+	return s
+}
+
+// memWatch delivers changes of a key, or of the keys under a prefix, to a Listener in
+// order, from its own goroutine, so that a slow Listener never blocks writers of the store.
+type memWatch struct {
+	key     string
+	prefix  bool
+	l       Listener
+	mu      sync.Mutex
+	cond    *sync.Cond
+	changes []*Change
+	err     error
+	done    bool
+	stopped chan struct{}
+}
+
+func newMemWatch(key string, prefix bool, l Listener) *memWatch {
+	w := &memWatch{key: key, prefix: prefix, l: l, stopped: make(chan struct{})}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+func (w *memWatch) notify(change *Change) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	w.changes = append(w.changes, change)
+	w.cond.Signal()
+}
+
+func (w *memWatch) bye(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	w.done = true
+	w.err = err
+	close(w.stopped)
+	w.cond.Signal()
+}
+
+func (w *memWatch) run() {
+	for {
+		w.mu.Lock()
+		for len(w.changes) == 0 && !w.done {
+			w.cond.Wait()
+		}
+		if len(w.changes) == 0 {
+			err := w.err
+			w.mu.Unlock()
+			w.l.Bye(err)
+			return
+		}
+		change := w.changes[0]
+		w.changes = w.changes[1:]
+		w.mu.Unlock()
+		w.l.Notify(change)
+	}
+}
+
+func (s *memStore) notify(key string, change *Change) {
+	change.Key = key
+	for _, w := range s.watches[key] {
+		w.notify(change)
+	}
+	for _, w := range s.prefixWatches {
+		if strings.HasPrefix(key, w.key) {
+			w.notify(change)
+		}
+	}
+}
+
+func (s *memStore) addWatch(w *memWatch) {
+	if w.prefix {
+		s.prefixWatches = append(s.prefixWatches, w)
+	} else {
+		s.watches[w.key] = append(s.watches[w.key], w)
+	}
+}
+
+func (s *memStore) removeWatch(w *memWatch) {
+	if w.prefix {
+		for i, other := range s.prefixWatches {
+			if other == w {
+				s.prefixWatches = append(s.prefixWatches[:i], s.prefixWatches[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	watches := s.watches[w.key]
+	for i, other := range watches {
+		if other == w {
+			s.watches[w.key] = append(watches[:i], watches[i+1:]...)
+			break
+		}
+	}
+	if len(s.watches[w.key]) == 0 {
+		delete(s.watches, w.key)
+	}
+}
+
+func (s *memStore) expireAfter(key string, e *memEntry, ttl time.Duration) {
+	e.expiry = s.clock.AfterFunc(ttl, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.entries[key] != e {
+			return
+		}
+		delete(s.entries, key)
+		s.notify(key, &Change{ChangeType: Deleted, PrevValue: e.value})
+	})
+}
+
+func (s *memStore) del(key string, e *memEntry) {
+	e.expiry.Stop()
+	delete(s.entries, key)
+	s.notify(key, &Change{ChangeType: Deleted, PrevValue: e.value})
+}
+
+func (mds *MemoryDataStore) checkOpen(ctx context.Context, op string) Error {
+	if err := ctx.Err(); err != nil {
+		if err == context.DeadlineExceeded {
+			return &OpError{code: Timeout, op: op, cause: err}
+		}
+		return &OpError{code: DataStoreError, op: op, cause: err}
+	}
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+	if mds.closed {
+		return &OpError{code: DataStoreError, op: op, cause: errors.New("Datastore is closed")}
+	}
+	return nil
+}
+
+func (mds *MemoryDataStore) PutIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (prevValue string, token uint64, err error) {
+	if err := mds.checkOpen(ctx, "PutIfAbsent"); err != nil {
+		return "", 0, err
+	}
+	s := mds.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		return e.value, e.created, &OpError{code: KeyExists, op: "PutIfAbsent", cause: errors.New("Key already exists")}
+	}
+	s.index++
+	e := &memEntry{value: value, created: s.index}
+	s.entries[key] = e
+	s.expireAfter(key, e, ttl)
+	s.notify(key, &Change{ChangeType: Created, NewValue: value})
+	return "", e.created, nil
+}
+
+func (mds *MemoryDataStore) RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if err := mds.checkOpen(ctx, "RefreshTTL"); err != nil {
+		return err
+	}
+	s := mds.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return &OpError{code: KeyNotFound, op: "RefreshTTL", cause: errors.New("Key not found")}
+	}
+	if e.value != value {
+		return &OpError{code: CompareFailed, op: "RefreshTTL", cause: errors.New("Value does not match")}
+	}
+	e.expiry.Stop()
+	s.expireAfter(key, e, ttl)
+	return nil
+}
+
+func (mds *MemoryDataStore) Get(ctx context.Context, key string) (string, uint64, error) {
+	if err := mds.checkOpen(ctx, "Get"); err != nil {
+		return "", 0, err
+	}
+	s := mds.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return "", 0, &OpError{code: KeyNotFound, op: "Get", cause: errors.New("Key not found")}
+	}
+	return e.value, e.created, nil
+}
+
+func (mds *MemoryDataStore) Del(ctx context.Context, key string) error {
+	if err := mds.checkOpen(ctx, "Del"); err != nil {
+		return err
+	}
+	s := mds.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return &OpError{code: KeyNotFound, op: "Del", cause: errors.New("Key not found")}
+	}
+	s.del(key, e)
+	return nil
+}
+
+func (mds *MemoryDataStore) CompareAndDel(ctx context.Context, key string, prevValue string) error {
+	if err := mds.checkOpen(ctx, "CompareAndDel"); err != nil {
+		return err
+	}
+	s := mds.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return &OpError{code: KeyNotFound, op: "CompareAndDel", cause: errors.New("Key not found")}
+	}
+	if e.value != prevValue {
+		return &OpError{code: CompareFailed, op: "CompareAndDel", cause: errors.New("Value does not match")}
+	}
+	s.del(key, e)
+	return nil
+}
+
+func (mds *MemoryDataStore) List(ctx context.Context, prefix string) (map[string]KeyValue, error) {
+	if err := mds.checkOpen(ctx, "List"); err != nil {
+		return nil, err
+	}
+	s := mds.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string]KeyValue)
+	for key, e := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = KeyValue{Value: e.value, Token: e.created}
+		}
+	}
+	return values, nil
+}
+
+func (mds *MemoryDataStore) Watch(ctx context.Context, key string, l Listener) error {
+	return mds.watch(ctx, "Watch", key, false, l)
+}
+
+func (mds *MemoryDataStore) WatchPrefix(ctx context.Context, prefix string, l Listener) error {
+	return mds.watch(ctx, "WatchPrefix", prefix, true, l)
+}
+
+func (mds *MemoryDataStore) watch(ctx context.Context, op string, key string, prefix bool, l Listener) error {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+	if mds.closed {
+		return &OpError{code: DataStoreError, op: op, cause: errors.New("Datastore is closed")}
+	}
+	w := newMemWatch(key, prefix, l)
+	s := mds.store
+	s.mu.Lock()
+	s.addWatch(w)
+	s.mu.Unlock()
+	mds.watches = append(mds.watches, w)
+	go func() {
+		select {
+		case <-ctx.Done():
+			mds.unwatch(w, &OpError{code: DataStoreError, op: op, cause: ctx.Err()})
+		case <-w.stopped:
+		}
+	}()
+	return nil
+}
+
+func (mds *MemoryDataStore) unwatch(w *memWatch, err error) {
+	mds.mu.Lock()
+	for i, other := range mds.watches {
+		if other == w {
+			mds.watches = append(mds.watches[:i], mds.watches[i+1:]...)
+			break
+		}
+	}
+	mds.mu.Unlock()
+	s := mds.store
+	s.mu.Lock()
+	s.removeWatch(w)
+	s.mu.Unlock()
+	w.bye(err)
+}
+
+func (mds *MemoryDataStore) Close() error {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+	if mds.closed {
+		return nil
+	}
+	mds.closed = true
+	s := mds.store
+	s.mu.Lock()
+	for _, w := range mds.watches {
+		s.removeWatch(w)
+	}
+	s.mu.Unlock()
+	for _, w := range mds.watches {
+		w.bye(&OpError{code: DataStoreError, op: "Watch", cause: errors.New("Datastore is closed")})
+	}
+	mds.watches = nil
+	return nil
+}
+
+func NewMemoryDataStore(ctx context.Context, conf *Config) (DataStore, error) {
+	return &MemoryDataStore{store: getMemStore(conf.Addresses, conf.clock())}, nil
+}
@@ -0,0 +1,221 @@
+package kingsmoot_test
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+func testMemoryConf(name string) *kingsmoot.Config {
+	return &kingsmoot.Config{
+		Name:            "akem",
+		DataStoreType:   "memory",
+		Addresses:       []string{name},
+		DsOpTimeout:     500 * time.Millisecond,
+		MasterDownAfter: 1 * time.Second}
+}
+
+func newMemoryDataStore(t *testing.T) kingsmoot.DataStore {
+	ds, err := kingsmoot.CreateDatastore(testMemoryConf(t.Name()))
+	assertNil(t, err, "Failed to create ds")
+	return ds
+}
+
+func TestMemoryPutIfAbsent(t *testing.T) {
+	ds := newMemoryDataStore(t)
+	defer ds.Close()
+	value := putIfAbsent(ds, t, "testkey", "testvalue123", 200*time.Millisecond)
+	if value != "" {
+		t.Fatalf("Not exptecting any value, got %v", value)
+	}
+	value = putIfAbsent(ds, t, "testkey", "testvalue456", 200*time.Millisecond)
+	if value != "testvalue123" {
+		t.Fatalf("Expected %v, got %v", "testvalue123", value)
+	}
+	time.Sleep(300 * time.Millisecond)
+	value = putIfAbsent(ds, t, "testkey", "testvalue456", 200*time.Millisecond)
+	if value != "" {
+		t.Fatalf("Not exptecting any value, got %v", value)
+	}
+}
+
+func TestMemoryRefreshTTL(t *testing.T) {
+	ds := newMemoryDataStore(t)
+	defer ds.Close()
+	putIfAbsent(ds, t, "testkey", "testvalue123", 200*time.Millisecond)
+	err := ds.RefreshTTL(context.Background(), "testkey", "testvalue456", 200*time.Millisecond)
+	assertNotNil(t, err, "RefreshTTL should have failed as value given is different")
+	if err.(kingsmoot.Error).Code() != kingsmoot.CompareFailed {
+		t.Fatal("RefreshTTL should have failed as due to CompareFailed", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 200*time.Millisecond)
+	assertNil(t, err, "Failed to RefreshTTL")
+	time.Sleep(150 * time.Millisecond)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 200*time.Millisecond)
+	assertNil(t, err, "Failed to RefreshTTL")
+	time.Sleep(300 * time.Millisecond)
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue123", 200*time.Millisecond)
+	assertNotNil(t, err, "Failed to RefreshTTL")
+	if err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		t.Fatal("RefreshTTL should have failed as due to Key getting expired", err)
+	}
+}
+
+func TestMemoryCompareAndDel(t *testing.T) {
+	ds := newMemoryDataStore(t)
+	defer ds.Close()
+	putIfAbsent(ds, t, "testkey", "testvalue123", 5*time.Second)
+	err := ds.CompareAndDel(context.Background(), "test123", "abcd")
+	assertNotNil(t, err, "Should have failed for a non existent key")
+	if err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		t.Fatal("Failed with wrong error code, should  have been KeyNotFound", err)
+	}
+	err = ds.CompareAndDel(context.Background(), "testkey", "abcd")
+	assertNotNil(t, err, "Should have failed as value is not same")
+	if err.(kingsmoot.Error).Code() != kingsmoot.CompareFailed {
+		t.Fatal("Failed with wrong error code, should  have been CompareFailed", err)
+	}
+	err = ds.CompareAndDel(context.Background(), "testkey", "testvalue123")
+	assertNil(t, err, "CompareAndDel should have been successful")
+}
+
+func TestMemoryWatch(t *testing.T) {
+	ds := newMemoryDataStore(t)
+	putIfAbsent(ds, t, "testkey", "testvalue123", 200*time.Millisecond)
+	l := newListener()
+	err := ds.Watch(context.Background(), "testkey", l)
+	assertNil(t, err, "Error while setting the Watch")
+	c, err := whatChanged(l.changeCh, 1*time.Second)
+	assertNil(t, err, "1:Should have got expire notification within 1 second")
+	if c.ChangeType != kingsmoot.Deleted || c.PrevValue != "testvalue123" {
+		t.Fatalf("Expected %v of %v Got %v", kingsmoot.Deleted, "testvalue123", c)
+	}
+	putIfAbsent(ds, t, "testkey", "testvalue456", 5*time.Second)
+	c, err = whatChanged(l.changeCh, 100*time.Millisecond)
+	assertNil(t, err, "2:Should have got create notification")
+	if c.ChangeType != kingsmoot.Created || c.NewValue != "testvalue456" {
+		t.Fatalf("Expected %v of %v Got %v", kingsmoot.Created, "testvalue456", c)
+	}
+	err = ds.RefreshTTL(context.Background(), "testkey", "testvalue456", 5*time.Second)
+	assertNil(t, err, "3:Should have refreshed ttl")
+	c, err = whatChanged(l.changeCh, 100*time.Millisecond)
+	assertNotNil(t, err, "4:Should not have got change notification for ttl refresh")
+	err = ds.CompareAndDel(context.Background(), "testkey", "testvalue456")
+	assertNil(t, err, "5:Should have deleted the key")
+	c, err = whatChanged(l.changeCh, 100*time.Millisecond)
+	assertNil(t, err, "6:Should have got delete notification")
+	if c.ChangeType != kingsmoot.Deleted || c.PrevValue != "testvalue456" {
+		t.Fatalf("Expected %v of %v Got %v", kingsmoot.Deleted, "testvalue456", c)
+	}
+	ds.Close()
+	select {
+	case <-l.errCh:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("7:Listener should have been let go on Close")
+	}
+}
+
+func TestMemoryWatchContext(t *testing.T) {
+	ds := newMemoryDataStore(t)
+	defer ds.Close()
+	l := newListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	err := ds.Watch(ctx, "testkey", l)
+	assertNil(t, err, "1:Error while setting the Watch")
+	cancel()
+	select {
+	case <-l.errCh:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("2:Listener should have been let go once context is cancelled")
+	}
+	putIfAbsent(ds, t, "testkey", "testvalue123", 5*time.Second)
+	_, err = whatChanged(l.changeCh, 100*time.Millisecond)
+	assertNotNil(t, err, "3:Should not have got change notification after the watch ended")
+}
+
+func TestMemoryJoinAsCandidate(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	err = km1.Join(c1.endpoint, c1)
+	assertNil(t, err, "2:Failed to join leader election")
+	defer km1.Exit()
+	state, err := readState(c1.roleCh, 20*time.Millisecond)
+	assertNil(t, err, fmt.Sprintf("3:Failed to get notification for %v", c1))
+	if state != kingsmoot.Leader {
+		t.Fatalf("%v should have been leader", c1)
+	}
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	err = km2.Join(c2.endpoint, c2)
+	assertNil(t, err, "5:Failed to join leader election")
+	defer km2.Exit()
+	state, err = readState(c2.roleCh, 20*time.Millisecond)
+	assertNil(t, err, fmt.Sprintf("6:Failed to get notification for %v", c2))
+	if state != kingsmoot.Follower {
+		t.Fatalf("Should have been follower %v", c2)
+	}
+	km1.Exit()
+	state, err = readState(c2.roleCh, 100*time.Millisecond)
+	assertNil(t, err, fmt.Sprintf("7:Failed to get notification for %v to become leader", c2))
+	if state != kingsmoot.Leader {
+		t.Fatalf("Should have been Leader %v", c2)
+	}
+	leader, err := km2.Leader()
+	assertNil(t, err, "8:Failed to get leader")
+	if leader != c2.endpoint {
+		t.Fatalf("Expected leader %v Got %v", c2.endpoint, leader)
+	}
+}
+
+func TestMemoryFencingToken(t *testing.T) {
+	ds := newMemoryDataStore(t)
+	defer ds.Close()
+	_, token1, err := ds.PutIfAbsent(context.Background(), "testkey", "testvalue123", 5*time.Second)
+	assertNil(t, err, "1:Failed to put")
+	_, token, err := ds.PutIfAbsent(context.Background(), "testkey", "testvalue456", 5*time.Second)
+	assertNotNil(t, err, "2:Put should have failed as key exists")
+	if token != token1 {
+		t.Fatalf("Expected token %v of the existing key, Got %v", token1, token)
+	}
+	assertNil(t, ds.CompareAndDel(context.Background(), "testkey", "testvalue123"), "3:Failed to delete")
+	_, token2, err := ds.PutIfAbsent(context.Background(), "testkey", "testvalue456", 5*time.Second)
+	assertNil(t, err, "4:Failed to put")
+	if token2 <= token1 {
+		t.Fatalf("Token of the new term %v should have been greater than %v", token2, token1)
+	}
+
+	conf := testMemoryConf(t.Name() + "/kingsmoot")
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "5:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "6:Failed to join leader election")
+	defer km1.Exit()
+	readState(c1.roleCh, 20*time.Millisecond)
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "7:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2), "8:Failed to join leader election")
+	defer km2.Exit()
+	readState(c2.roleCh, 20*time.Millisecond)
+	if c1.term() == 0 || c2.term() != c1.term() {
+		t.Fatalf("Leader and follower should agree on the term, Got %v and %v", c1.term(), c2.term())
+	}
+	km1.Exit()
+	state, err := readState(c2.roleCh, 100*time.Millisecond)
+	assertNil(t, err, "9:Failed to get notification")
+	if state != kingsmoot.Leader || c2.term() <= c1.term() {
+		t.Fatalf("%v should have been Leader with term greater than %v, Got %v", c2, c1.term(), c2.term())
+	}
+}
+
+func TestMemoryListAndWatchPrefix(t *testing.T) {
+	ds := newMemoryDataStore(t)
+	defer ds.Close()
+	listAndWatchPrefix(t, ds)
+}
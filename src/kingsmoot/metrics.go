@@ -0,0 +1,191 @@
+package kingsmoot
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics of every Kingsmoot and DataStore in the process, labelled by Config.Name as
+// service. They are exposed in the Prometheus text format by MetricsHandler.
+var (
+	electionsWon = newMetricVec("kingsmoot_elections_won_total", "Number of times a candidate became leader.",
+		"counter", nil, "service")
+	electionsLost = newMetricVec("kingsmoot_elections_lost_total", "Number of times a candidate campaigned and became follower of another leader.",
+		"counter", nil, "service")
+	leaderSeconds = newMetricVec("kingsmoot_leader_seconds", "Time a candidate stayed leader for, per term.",
+		"histogram", []float64{1, 10, 60, 300, 1800, 3600, 6 * 3600, 24 * 3600}, "service")
+	leaderChanges = newMetricVec("kingsmoot_leader_changes_total", "Number of changes of leader observed.",
+		"counter", nil, "service")
+	roleGauge = newMetricVec("kingsmoot_role", "Current role of the candidate, 0:NotAMember 1:Follower 2:Leader 3:Dead.",
+		"gauge", nil, "service")
+	refreshFailures = newMetricVec("kingsmoot_refresh_failures_total", "Number of times the leader failed to refresh its TTL.",
+		"counter", nil, "service")
+	putIfAbsentRetries = newMetricVec("kingsmoot_putifabsent_retries_total", "Number of times a candidate campaigned again after its last attempt failed on the datastore.",
+		"counter", nil, "service")
+	watchRestarts = newMetricVec("kingsmoot_watch_restarts_total", "Number of times the watch of the leader key was set up again.",
+		"counter", nil, "service")
+	dsOpSeconds = newMetricVec("kingsmoot_datastore_op_seconds", "Latency of datastore operations.",
+		"histogram", []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "service", "op")
+	dsOpFailures = newMetricVec("kingsmoot_datastore_op_failures_total", "Number of failed datastore operations, by error code.",
+		"counter", nil, "service", "op", "code")
+
+	allMetrics = []*metricVec{electionsWon, electionsLost, leaderSeconds, leaderChanges, roleGauge,
+		refreshFailures, putIfAbsentRetries, watchRestarts, dsOpSeconds, dsOpFailures}
+)
+
+// MetricsHandler serves the metrics of Kingsmoot in the Prometheus text format, to be
+// mounted on the /metrics path scraped by Prometheus.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the metrics of Kingsmoot in the Prometheus text format
+func WriteMetrics(w io.Writer) error {
+	for _, m := range allMetrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metricVec is a family of series of one metric, one for each combination of label values
+type metricVec struct {
+	name    string
+	help    string
+	typ     string
+	buckets []float64
+	labels  []string
+	mu      sync.Mutex //Protects series
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+func newMetricVec(name string, help string, typ string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, typ: typ, buckets: buckets, labels: labels, series: make(map[string]*series)}
+}
+
+// get must be called with m.mu held
+func (m *metricVec) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metricVec) add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value += v
+}
+
+func (m *metricVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *metricVec) set(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value = v
+}
+
+func (m *metricVec) observe(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(labelValues)
+	for i, upper := range m.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+func (m *metricVec) since(start time.Time, labelValues ...string) {
+	m.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (m *metricVec) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.series) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if _, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, m.typ); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		s := m.series[k]
+		var err error
+		if m.typ == "histogram" {
+			for i, upper := range m.buckets {
+				if _, err = fmt.Fprintf(w, "%v_bucket%v %v\n", m.name, m.labelPairs(s, "le", formatFloat(upper)), s.counts[i]); err != nil {
+					return err
+				}
+			}
+			_, err = fmt.Fprintf(w, "%v_bucket%v %v\n%v_sum%v %v\n%v_count%v %v\n",
+				m.name, m.labelPairs(s, "le", "+Inf"), s.count,
+				m.name, m.labelPairs(s), formatFloat(s.value),
+				m.name, m.labelPairs(s), s.count)
+		} else {
+			_, err = fmt.Fprintf(w, "%v%v %v\n", m.name, m.labelPairs(s), formatFloat(s.value))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelPairs formats the labels of s, followed by extra name value pairs
+func (m *metricVec) labelPairs(s *series, extra ...string) string {
+	pairs := make([]string, 0, len(m.labels)+len(extra)/2)
+	for i, name := range m.labels {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", name, escapeLabel(s.labelValues[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
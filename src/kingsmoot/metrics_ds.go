@@ -0,0 +1,91 @@
+package kingsmoot
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// instrumentedDataStore records latency and failures of every operation of a DataStore
+type instrumentedDataStore struct {
+	DataStore
+	service string
+}
+
+func instrument(ds DataStore, service string) DataStore {
+	return &instrumentedDataStore{DataStore: ds, service: service}
+}
+
+func (ids *instrumentedDataStore) shareLeases() {
+	if ls, ok := ids.DataStore.(leaseSharer); ok {
+		ls.shareLeases()
+	}
+}
+
+func (ids *instrumentedDataStore) record(op string, start time.Time, err error) {
+	dsOpSeconds.since(start, ids.service, op)
+	if err == nil {
+		return
+	}
+	code := "Unknown"
+	if e, ok := err.(Error); ok {
+		code = e.Code().String()
+	}
+	dsOpFailures.inc(ids.service, op, code)
+}
+
+func (ids *instrumentedDataStore) PutIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (prevValue string, token uint64, err error) {
+	start := time.Now()
+	prevValue, token, err = ids.DataStore.PutIfAbsent(ctx, key, value, ttl)
+	ids.record("PutIfAbsent", start, err)
+	return
+}
+
+func (ids *instrumentedDataStore) RefreshTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	start := time.Now()
+	err := ids.DataStore.RefreshTTL(ctx, key, value, ttl)
+	ids.record("RefreshTTL", start, err)
+	return err
+}
+
+func (ids *instrumentedDataStore) Get(ctx context.Context, key string) (string, uint64, error) {
+	start := time.Now()
+	value, token, err := ids.DataStore.Get(ctx, key)
+	ids.record("Get", start, err)
+	return value, token, err
+}
+
+func (ids *instrumentedDataStore) Del(ctx context.Context, key string) error {
+	start := time.Now()
+	err := ids.DataStore.Del(ctx, key)
+	ids.record("Del", start, err)
+	return err
+}
+
+func (ids *instrumentedDataStore) CompareAndDel(ctx context.Context, key string, prevValue string) error {
+	start := time.Now()
+	err := ids.DataStore.CompareAndDel(ctx, key, prevValue)
+	ids.record("CompareAndDel", start, err)
+	return err
+}
+
+func (ids *instrumentedDataStore) Watch(ctx context.Context, key string, l Listener) error {
+	start := time.Now()
+	err := ids.DataStore.Watch(ctx, key, l)
+	ids.record("Watch", start, err)
+	return err
+}
+
+func (ids *instrumentedDataStore) List(ctx context.Context, prefix string) (map[string]KeyValue, error) {
+	start := time.Now()
+	values, err := ids.DataStore.List(ctx, prefix)
+	ids.record("List", start, err)
+	return values, err
+}
+
+func (ids *instrumentedDataStore) WatchPrefix(ctx context.Context, prefix string, l Listener) error {
+	start := time.Now()
+	err := ids.DataStore.WatchPrefix(ctx, prefix, l)
+	ids.record("WatchPrefix", start, err)
+	return err
+}
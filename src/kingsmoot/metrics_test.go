@@ -0,0 +1,87 @@
+package kingsmoot_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"kingsmoot"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the samples served by the metrics handler by series, and the whole text
+func scrape(t *testing.T, url string) (map[string]float64, string) {
+	resp, err := http.Get(url)
+	assertNil(t, err, "Failed to scrape metrics")
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assertNil(t, err, "Failed to read metrics")
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.LastIndex(line, " ")
+		if strings.HasPrefix(line, "#") || i < 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		assertNil(t, err, "Failed to parse sample "+line)
+		samples[line[:i]] = v
+	}
+	return samples, string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	server := httptest.NewServer(kingsmoot.MetricsHandler())
+	defer server.Close()
+	// Metrics are process wide, so the test looks at what the election added to them
+	before, _ := scrape(t, server.URL)
+
+	conf := testMemoryConf(t.Name())
+	conf.Name = "metricsakem"
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2), "5:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "6")
+	km1.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 100*time.Millisecond, "7")
+
+	after, metrics := scrape(t, server.URL)
+	if !strings.Contains(metrics, "# TYPE kingsmoot_elections_won_total counter\n") {
+		t.Fatalf("8:Expected type of elections_won_total in metrics\n%v", metrics)
+	}
+	for series, delta := range map[string]float64{
+		`kingsmoot_elections_won_total{service="metricsakem"}`:             2,
+		`kingsmoot_elections_lost_total{service="metricsakem"}`:            1,
+		`kingsmoot_leader_seconds_count{service="metricsakem"}`:            1,
+		`kingsmoot_leader_seconds_bucket{service="metricsakem",le="+Inf"}`: 1,
+		// Campaigns of a follower are no retries, no campaign failed on the datastore
+		`kingsmoot_putifabsent_retries_total{service="metricsakem"}`: 0,
+	} {
+		if got := after[series] - before[series]; got != delta {
+			t.Fatalf("9:Expected %v to grow by %v Got %v\n%v", series, delta, got, metrics)
+		}
+	}
+	if role := after[`kingsmoot_role{service="metricsakem"}`]; role != float64(kingsmoot.Leader) {
+		t.Fatalf("10:Expected role %v Got %v", float64(kingsmoot.Leader), role)
+	}
+	for _, series := range []string{
+		`kingsmoot_datastore_op_seconds_bucket{service="metricsakem",op="PutIfAbsent",le="+Inf"}`,
+		`kingsmoot_datastore_op_failures_total{service="metricsakem",op="PutIfAbsent",code="KeyExists"}`,
+	} {
+		if after[series] <= before[series] {
+			t.Fatalf("11:Expected %v to grow Got %v\n%v", series, after[series], metrics)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package kingsmoot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// PartitionCandidate is told of its membership in the election of every partition
+type PartitionCandidate interface {
+	fmt.Stringer
+	UpdatePartitionMembership(partition int, memberShip MemberShip) error
+}
+
+// PartitionedElection runs an election per partition, 0 to partitions-1, for a pool of
+// candidates which join it once. The elections share one connection to the datastore, and
+// are balanced so that each candidate leads its fair share of partitions, rounded up: a
+// candidate leading its share does not campaign for more, and one leading more than that
+// steps down from the extra partitions when candidates join the pool. A PartitionCandidate
+// which is also a HealthChecker is health checked in every election, as a Candidate is.
+type PartitionedElection struct {
+	conf       *Config
+	ds         DataStore
+	partitions int
+	pool       *Kingsmoot //Keeps the membership of the candidate in the pool, never campaigns
+	kms        []*Kingsmoot
+	mu         sync.Mutex //Protects everything below
+	led        map[int]bool
+	members    int
+}
+
+func NewPartitionedElection(conf *Config, partitions int) (*PartitionedElection, error) {
+	return NewPartitionedElectionContext(context.Background(), conf, partitions)
+}
+
+// NewPartitionedElectionContext is NewPartitionedElection with ctx bounding the connection
+// to the datastore
+func NewPartitionedElectionContext(ctx context.Context, conf *Config, partitions int) (*PartitionedElection, error) {
+	if err := checkPartitions(partitions); err != nil {
+		return nil, err
+	}
+	ds, err := CreateDatastoreContext(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return newPartitionedElection(conf, ds, partitions), nil
+}
+
+func checkPartitions(partitions int) error {
+	if partitions < 1 {
+		return &InvalidArgumentError{code: InvalidArgument, Name: "partitions", Value: fmt.Sprint(partitions), Expected: "At least 1 partition"}
+	}
+	return nil
+}
+
+func newPartitionedElection(conf *Config, ds DataStore, partitions int) *PartitionedElection {
+	shared := &sharedDataStore{DataStore: ds}
+	pe := &PartitionedElection{conf: conf, ds: ds, partitions: partitions, pool: newKingsmoot(conf, shared), led: make(map[int]bool)}
+	for p := 0; p < partitions; p++ {
+		partConf := *conf
+		partConf.Name = fmt.Sprintf("%v.partitions/%v", conf.Name, p)
+		km := newKingsmoot(&partConf, shared)
+		partition := p
+		km.mayLead = func() bool { return pe.mayLead(partition) }
+		pe.kms = append(pe.kms, km)
+	}
+	return pe
+}
+
+// sharedDataStore is the DataStore of every election of a PartitionedElection, which is
+// only closed once all of them have exited
+type sharedDataStore struct {
+	DataStore
+}
+
+func (sds *sharedDataStore) Close() error {
+	return nil
+}
+
+func (pe *PartitionedElection) Join(endpoint string, c PartitionCandidate) error {
+	return pe.JoinContext(context.Background(), endpoint, c)
+}
+
+// JoinContext is Join with ctx bounding the first round of every election
+func (pe *PartitionedElection) JoinContext(ctx context.Context, endpoint string, c PartitionCandidate) error {
+	pool := pe.pool
+	pool.mu.Lock()
+	if pool.role == Dead {
+		pool.mu.Unlock()
+		return errors.New("PartitionedElection closed, create new instance to join")
+	}
+	if pool.endpoint != "" {
+		pool.mu.Unlock()
+		return errors.New(fmt.Sprintf("Already in use for %v, create new instance to join", pool.endpoint))
+	}
+	pool.endpoint = endpoint
+	pool.updateLogger()
+	pool.mu.Unlock()
+	if err := pool.register(ctx); err != nil {
+		return err
+	}
+	if err := pool.WatchMembers(pool.ctx, pe.onMembers); err != nil {
+		return err
+	}
+	for p, km := range pe.kms {
+		if err := km.JoinContext(ctx, endpoint, &partitionCandidate{pe: pe, partition: p, c: c}); err != nil {
+			return err
+		}
+	}
+	go pe.loop()
+	return nil
+}
+
+// Leading returns the partitions the candidate leads, in order
+func (pe *PartitionedElection) Leading() []int {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	var leading []int
+	for p, led := range pe.led {
+		if led {
+			leading = append(leading, p)
+		}
+	}
+	sort.Ints(leading)
+	return leading
+}
+
+func (pe *PartitionedElection) Exit() {
+	pe.ExitContext(context.Background())
+}
+
+// ExitContext is Exit with ctx bounding the release of leadership of every partition
+func (pe *PartitionedElection) ExitContext(ctx context.Context) error {
+	var exitErr error
+	for _, km := range pe.kms {
+		if err := km.ExitContext(ctx); err != nil {
+			exitErr = err
+		}
+	}
+	if err := pe.pool.ExitContext(ctx); err != nil {
+		exitErr = err
+	}
+	if err := pe.ds.Close(); err != nil {
+		exitErr = err
+	}
+	return exitErr
+}
+
+// share is the number of partitions each candidate in the pool should lead, rounded up.
+// Must be called with pe.mu held.
+func (pe *PartitionedElection) share() int {
+	members := pe.members
+	if members < 1 {
+		members = 1
+	}
+	return (pe.partitions + members - 1) / members
+}
+
+// leading returns the number of partitions led, other than partition. Must be called with
+// pe.mu held.
+func (pe *PartitionedElection) leading(partition int) int {
+	n := 0
+	for p, led := range pe.led {
+		if led && p != partition {
+			n++
+		}
+	}
+	return n
+}
+
+func (pe *PartitionedElection) mayLead(partition int) bool {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	return pe.leading(partition) < pe.share()
+}
+
+func (pe *PartitionedElection) onMembers(members []Member) {
+	pe.mu.Lock()
+	pe.members = len(members)
+	pe.mu.Unlock()
+	pe.rebalance()
+}
+
+// loop keeps the membership of the candidate in the pool alive and rebalances every
+// MasterDownAfter/2, till Exit
+func (pe *PartitionedElection) loop() {
+	for {
+		select {
+		case <-pe.conf.clock().After(pe.conf.MasterDownAfter / 2):
+		case <-pe.pool.ctx.Done():
+			return
+		}
+		pe.pool.refreshMember(pe.pool.ctx)
+		pe.rebalance()
+	}
+}
+
+// rebalance steps down from the partitions led beyond the fair share, highest first
+func (pe *PartitionedElection) rebalance() {
+	pe.mu.Lock()
+	var extra []int
+	for p := pe.partitions - 1; p >= 0 && pe.leading(-1)-len(extra) > pe.share(); p-- {
+		if pe.led[p] {
+			extra = append(extra, p)
+		}
+	}
+	share := pe.share()
+	pe.mu.Unlock()
+	for _, p := range extra {
+		km := pe.kms[p]
+		km.log().Infof("%v leads more than its share of %v partitions, stepping down", km.c, share)
+		km.StepDown(km.ctx)
+	}
+}
+
+// partitionCandidate is the Candidate of the election of one partition
+type partitionCandidate struct {
+	pe        *PartitionedElection
+	partition int
+	c         PartitionCandidate
+}
+
+func (pc *partitionCandidate) UpdateMembership(memberShip MemberShip) error {
+	pc.pe.mu.Lock()
+	pc.pe.led[pc.partition] = memberShip.Role == Leader
+	pc.pe.mu.Unlock()
+	return pc.c.UpdatePartitionMembership(pc.partition, memberShip)
+}
+
+func (pc *partitionCandidate) CheckHealth(ctx context.Context) error {
+	if hc, ok := pc.c.(HealthChecker); ok {
+		return hc.CheckHealth(ctx)
+	}
+	return nil
+}
+
+func (pc *partitionCandidate) String() string {
+	return fmt.Sprintf("%v[%v]", pc.c, pc.partition)
+}
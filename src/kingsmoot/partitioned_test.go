@@ -0,0 +1,67 @@
+package kingsmoot_test
+
+import (
+	"fmt"
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+// partitionNode is a PartitionCandidate which keeps the role of every partition
+type partitionNode struct {
+	endpoint string
+}
+
+func (n *partitionNode) UpdatePartitionMembership(partition int, memberShip kingsmoot.MemberShip) error {
+	return nil
+}
+
+func (n *partitionNode) String() string {
+	return n.endpoint
+}
+
+func awaitLeading(t *testing.T, pe *kingsmoot.PartitionedElection, expected int, timeout time.Duration, step string) {
+	timeoutCh := time.After(timeout)
+	for {
+		if len(pe.Leading()) == expected {
+			return
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeoutCh:
+			t.Fatalf("%v:Should have led %v partitions within %v, Got %v", step, expected, timeout, pe.Leading())
+		}
+	}
+}
+
+func TestPartitionedElection(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	_, err := kingsmoot.NewPartitionedElection(conf, 0)
+	assertNotNil(t, err, "1:Should need at least one partition")
+	pe1, err := kingsmoot.NewPartitionedElection(conf, 6)
+	assertNil(t, err, "2:Failed to create partitioned election")
+	defer pe1.Exit()
+	assertNil(t, pe1.Join("akem1:6379", &partitionNode{endpoint: "akem1:6379"}), "3:Failed to join")
+	awaitLeading(t, pe1, 6, 100*time.Millisecond, "4")
+
+	pe2, err := kingsmoot.NewPartitionedElection(conf, 6)
+	assertNil(t, err, "5:Failed to create partitioned election")
+	assertNil(t, pe2.Join("akem2:6379", &partitionNode{endpoint: "akem2:6379"}), "6:Failed to join")
+	awaitLeading(t, pe2, 3, 2*time.Second, "7")
+	awaitLeading(t, pe1, 3, 100*time.Millisecond, "8")
+	for _, p := range pe2.Leading() {
+		partConf := *conf
+		partConf.Name = fmt.Sprintf("%v.partitions/%v", conf.Name, p)
+		leader, err := kingsmoot.NewFromConf(&partConf)
+		assertNil(t, err, "9:Failed to create kingsmoot")
+		endpoint, err := leader.Leader()
+		leader.Exit()
+		assertNil(t, err, "10:Failed to get leader")
+		if endpoint != "akem2:6379" {
+			t.Fatalf("11:Expected akem2:6379 to lead partition %v, Got %v", p, endpoint)
+		}
+	}
+
+	pe2.Exit()
+	awaitLeading(t, pe1, 6, 2*time.Second, "12")
+}
@@ -0,0 +1,106 @@
+package kingsmoot
+
+import (
+	"encoding/json"
+
+	"golang.org/x/net/context"
+)
+
+// JoinOption changes how a candidate takes part in the election
+type JoinOption func(km *Kingsmoot)
+
+// WithPriority joins with the given priority, zero by default. A healthy follower with a
+// higher priority than the leader claims leadership, and the leader hands it over to the
+// highest claim it finds when it next refreshes.
+func WithPriority(priority int) JoinOption {
+	return func(km *Kingsmoot) {
+		km.priority = priority
+	}
+}
+
+// preemptClaim is what a follower with a higher priority than the leader writes into the
+// preempt key. The key holds the claim of the highest priority.
+type preemptClaim struct {
+	Endpoint string `json:"endpoint"`
+	Priority int    `json:"priority"`
+}
+
+func (c preemptClaim) encode() string {
+	b, _ := json.Marshal(c)
+	return string(b)
+}
+
+func decodePreemptClaim(value string) (preemptClaim, bool) {
+	var c preemptClaim
+	if json.Unmarshal([]byte(value), &c) != nil || c.Endpoint == "" {
+		return preemptClaim{}, false
+	}
+	return c, true
+}
+
+func (km *Kingsmoot) preemptKey() string {
+	return km.conf.Name + ".preempt"
+}
+
+// claim returns the preempt claim of the candidate, empty if it never preempts anyone
+func (km *Kingsmoot) claim() string {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if km.priority <= 0 {
+		return ""
+	}
+	return preemptClaim{Endpoint: km.endpoint, Priority: km.priority}.encode()
+}
+
+// preempt claims leadership if the candidate is a healthy follower of a leader with a
+// lower priority, and keeps its claim alive for as long as that holds
+func (km *Kingsmoot) preempt(ctx context.Context) error {
+	km.mu.Lock()
+	role, priority, leader, unhealthy := km.role, km.priority, km.currRecord, km.unhealthy
+	km.mu.Unlock()
+	if role != Follower || leader.Endpoint == "" || priority <= leader.Priority || unhealthy > 0 {
+		return nil
+	}
+	claim := km.claim()
+	value, _, err := km.ds.Get(ctx, km.preemptKey())
+	if err != nil {
+		if err.(Error).Code() != KeyNotFound {
+			return err
+		}
+	} else if value == claim {
+		return km.ds.RefreshTTL(ctx, km.preemptKey(), claim, km.conf.MasterDownAfter)
+	} else if other, ok := decodePreemptClaim(value); ok && other.Priority >= priority {
+		return nil
+	} else if err = km.ds.CompareAndDel(ctx, km.preemptKey(), value); err != nil {
+		return err
+	}
+	_, _, err = km.ds.PutIfAbsent(ctx, km.preemptKey(), claim, km.conf.MasterDownAfter)
+	if err == nil {
+		km.log().Infof("%v with priority %v claimed leadership of %v from %v with priority %v", km.c, priority, km.conf.Name, leader.Endpoint, leader.Priority)
+	}
+	return err
+}
+
+// yield transfers leadership to the follower holding the preempt claim, if its priority is
+// higher than that of the leader
+func (km *Kingsmoot) yield(ctx context.Context) error {
+	km.mu.Lock()
+	role, endpoint, priority := km.role, km.endpoint, km.priority
+	km.mu.Unlock()
+	if role != Leader {
+		return nil
+	}
+	value, _, err := km.ds.Get(ctx, km.preemptKey())
+	if err != nil {
+		if err.(Error).Code() == KeyNotFound {
+			return nil
+		}
+		return err
+	}
+	claim, ok := decodePreemptClaim(value)
+	if !ok || claim.Priority <= priority || claim.Endpoint == endpoint {
+		return nil
+	}
+	km.log().Infof("%v with priority %v yielding leadership of %v to %v with priority %v", km.c, priority, km.conf.Name, claim.Endpoint, claim.Priority)
+	return km.TransferToContext(ctx, claim.Endpoint)
+}
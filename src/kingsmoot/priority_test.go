@@ -0,0 +1,48 @@
+package kingsmoot_test
+
+import (
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+func TestPriorityPreemption(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+
+	// Candidate with a higher priority takes over from the leader
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "4:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2, kingsmoot.WithPriority(10)), "5:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "6")
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 2*time.Second, "7")
+	awaitState(t, c1.roleCh, kingsmoot.Follower, 100*time.Millisecond, "8")
+	record, err := km1.LeaderRecord()
+	assertNil(t, err, "9:Failed to get leader record")
+	if record.Endpoint != c2.endpoint || record.Priority != 10 {
+		t.Fatalf("10:Expected %v with priority 10 as leader, Got %+v", c2.endpoint, record)
+	}
+
+	// Candidate with a lower priority stays follower
+	c3 := CreateCandidate("akem3:6379")
+	km3, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "11:Failed to create kingsmoot")
+	assertNil(t, km3.Join(c3.endpoint, c3, kingsmoot.WithPriority(5)), "12:Failed to join leader election")
+	defer km3.Exit()
+	for state, err := readState(c3.roleCh, 1500*time.Millisecond); err == nil; state, err = readState(c3.roleCh, 1500*time.Millisecond) {
+		if state != kingsmoot.Follower {
+			t.Fatalf("13:%v with lower priority should have stayed follower, Got %v", c3, state)
+		}
+	}
+
+	// Once the preferred leader is gone, the next in priority preempts whoever took over
+	km2.Exit()
+	awaitState(t, c3.roleCh, kingsmoot.Leader, 3*time.Second, "14")
+}
@@ -0,0 +1,206 @@
+// Package proxy forwards HTTP requests to whichever candidate leads an election, for
+// clients of services which must only be written to through their leader.
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"kingsmoot"
+)
+
+// DefaultRetryWait is how long a request which failed on the leader waits for another
+// leader to retry on, unless Transport.RetryWait says otherwise
+const DefaultRetryWait = time.Second
+
+// maxRetryBody is the largest body of a proxied request which is buffered to be retried
+const maxRetryBody = 1 << 20
+
+// Transport is an http.RoundTripper sending every request to the endpoint in the leader
+// key of an election, whatever host its URL names. Endpoints which are not URLs are taken
+// as host:port of plain http. While there is no leader it answers 503 Service Unavailable
+// without sending the request.
+//
+// A request failing on the leader, or answered with 503 as a leader stepping down would,
+// is retried once on the next leader if one is elected within RetryWait. Requests with a
+// body are only retried if it can be read again, through http.Request.GetBody.
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport if nil
+	Base      http.RoundTripper
+	RetryWait time.Duration
+	name      string
+	mu        sync.Mutex //Protects everything below
+	leader    string
+	changed   chan struct{} //Closed on the next change of leader
+}
+
+// NewTransport follows the leader of the election of km, which should be a Kingsmoot of
+// its own rather than one which joined the election. It follows the leader till km exits.
+func NewTransport(km *kingsmoot.Kingsmoot) (*Transport, error) {
+	leader, err := km.LeaderRecord()
+	if err != nil && err.(kingsmoot.Error).Code() != kingsmoot.KeyNotFound {
+		return nil, err
+	}
+	t := &Transport{RetryWait: DefaultRetryWait, name: km.Status().Service, leader: leader.Endpoint, changed: make(chan struct{})}
+	if err := km.WatchLeader(context.Background(), t.setLeader); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Transport) setLeader(leader kingsmoot.LeaderRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if leader.Endpoint == t.leader {
+		return
+	}
+	t.leader = leader.Endpoint
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Leader returns the endpoint requests are sent to, "" if there is no leader
+func (t *Transport) Leader() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.leader
+}
+
+func (t *Transport) current() (string, chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.leader, t.changed
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	leader, changed := t.current()
+	if leader == "" {
+		return t.unavailable(req), nil
+	}
+	resp, err := t.send(req, leader)
+	if (err == nil && resp.StatusCode != http.StatusServiceUnavailable) || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	next := t.awaitLeader(req, leader, changed)
+	if next == "" {
+		return resp, err
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(req.Context())
+		req.Body = body
+	}
+	return t.send(req, next)
+}
+
+// awaitLeader waits up to RetryWait for a leader other than prev, and returns it, or ""
+// if there is none by then
+func (t *Transport) awaitLeader(req *http.Request, prev string, changed chan struct{}) string {
+	timeout := time.After(t.RetryWait)
+	for {
+		select {
+		case <-changed:
+		case <-timeout:
+			return ""
+		case <-req.Context().Done():
+			return ""
+		}
+		var leader string
+		leader, changed = t.current()
+		if leader != "" && leader != prev {
+			return leader
+		}
+	}
+}
+
+// send sends a copy of req to leader
+func (t *Transport) send(req *http.Request, leader string) (*http.Response, error) {
+	target, err := endpointURL(leader)
+	if err != nil {
+		return nil, err
+	}
+	out := req.WithContext(req.Context())
+	u := *req.URL
+	u.Scheme, u.Host = target.Scheme, target.Host
+	out.URL, out.Host = &u, ""
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(out)
+}
+
+func endpointURL(endpoint string) (*url.URL, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, errors.New(fmt.Sprintf("Leader endpoint [%v] is not a URL or host:port", endpoint))
+	}
+	return u, nil
+}
+
+func (t *Transport) unavailable(req *http.Request) *http.Response {
+	body := fmt.Sprintf("No leader of %v\n", t.name)
+	return &http.Response{
+		Status:        "503 Service Unavailable",
+		StatusCode:    http.StatusServiceUnavailable,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req}
+}
+
+// NewReverseProxy proxies every request to the leader through t. Bodies of up to 1MB are
+// buffered so that requests carrying them can be retried on the next leader.
+func NewReverseProxy(t *Transport) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Transport: t,
+		Director: func(req *http.Request) {
+			// Transport sends the request to the leader, whatever host is set here
+			req.URL.Scheme, req.URL.Host, req.Host = "http", "leader", ""
+			if req.Body == nil || req.ContentLength < 0 || req.ContentLength > maxRetryBody {
+				return
+			}
+			b, err := ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				req.Body = ioutil.NopCloser(errReader{err})
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(b))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(b)), nil
+			}
+		}}
+}
+
+// errReader fails every read with err, so that a body which could not be buffered fails
+// the request the way reading it would have
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,170 @@
+package proxy_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"kingsmoot"
+	"kingsmoot/proxy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConf(name string) *kingsmoot.Config {
+	return &kingsmoot.Config{
+		Name:             "akem",
+		DataStoreType:    "memory",
+		Addresses:        []string{name},
+		DsOpTimeout:      500 * time.Millisecond,
+		MasterDownAfter:  1 * time.Second,
+		StepDownCooldown: time.Minute}
+}
+
+type candidate struct {
+	endpoint string
+	roleCh   chan kingsmoot.Role
+}
+
+func (c *candidate) UpdateMembership(memberShip kingsmoot.MemberShip) error {
+	c.roleCh <- memberShip.Role
+	return nil
+}
+
+func (c *candidate) String() string {
+	return c.endpoint
+}
+
+// backend is an http server named name, which joins the election with its URL as endpoint
+func backend(t *testing.T, conf *kingsmoot.Config, name string, handler func(km *kingsmoot.Kingsmoot, w http.ResponseWriter, r *http.Request)) (*kingsmoot.Kingsmoot, *httptest.Server) {
+	km, err := kingsmoot.NewFromConf(conf)
+	if err != nil {
+		t.Fatal("Failed to create kingsmoot", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler != nil {
+			handler(km, w, r)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%v %v %s", name, r.URL.Path, body)
+	}))
+	c := &candidate{endpoint: server.URL, roleCh: make(chan kingsmoot.Role, 16)}
+	if err := km.Join(c.endpoint, c); err != nil {
+		t.Fatal("Failed to join leader election", err)
+	}
+	return km, server
+}
+
+func get(t *testing.T, client *http.Client, method string, body string, step string) (int, string) {
+	req, err := http.NewRequest(method, "http://akem/path", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(step, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%v:Request failed %v", step, err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func awaitLeader(t *testing.T, pt *proxy.Transport, expected string, step string) {
+	timeout := time.After(time.Second)
+	for pt.Leader() != expected {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("%v:Leader should have been %v Got %v", step, expected, pt.Leader())
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	conf := testConf(t.Name())
+	observer, err := kingsmoot.NewFromConf(conf)
+	if err != nil {
+		t.Fatal("1:Failed to create kingsmoot", err)
+	}
+	defer observer.Exit()
+	pt, err := proxy.NewTransport(observer)
+	if err != nil {
+		t.Fatal("2:Failed to create transport", err)
+	}
+	client := &http.Client{Transport: pt}
+	if code, body := get(t, client, "GET", "", "3"); code != http.StatusServiceUnavailable || body != "No leader of akem\n" {
+		t.Fatalf("3:Expected 503 without leader Got %v %v", code, body)
+	}
+
+	// akem1 steps down on the first request, which is retried on akem2
+	km1, s1 := backend(t, conf, "akem1", func(km *kingsmoot.Kingsmoot, w http.ResponseWriter, r *http.Request) {
+		km.StepDown(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer s1.Close()
+	defer km1.Exit()
+	awaitLeader(t, pt, s1.URL, "4")
+	km2, s2 := backend(t, conf, "akem2", nil)
+	defer s2.Close()
+	defer km2.Exit()
+	if code, body := get(t, client, "POST", "write", "5"); code != http.StatusOK || body != "akem2 /path write" {
+		t.Fatalf("5:Expected request to be retried on akem2 Got %v %v", code, body)
+	}
+	awaitLeader(t, pt, s2.URL, "6")
+
+	// akem2 going away is retried on akem3, once it leads
+	km3, s3 := backend(t, conf, "akem3", nil)
+	defer s3.Close()
+	defer km3.Exit()
+	s2.Close()
+	go func() {
+		<-time.After(50 * time.Millisecond)
+		km2.Exit()
+	}()
+	if code, body := get(t, client, "GET", "", "7"); code != http.StatusOK || body != "akem3 /path " {
+		t.Fatalf("7:Expected request to be retried on akem3 Got %v %v", code, body)
+	}
+}
+
+func TestReverseProxy(t *testing.T) {
+	conf := testConf(t.Name())
+	observer, err := kingsmoot.NewFromConf(conf)
+	if err != nil {
+		t.Fatal("1:Failed to create kingsmoot", err)
+	}
+	defer observer.Exit()
+	pt, err := proxy.NewTransport(observer)
+	if err != nil {
+		t.Fatal("2:Failed to create transport", err)
+	}
+	front := httptest.NewServer(proxy.NewReverseProxy(pt))
+	defer front.Close()
+	client := &http.Client{}
+	resp, err := client.Get(front.URL + "/path")
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("3:Expected 503 without leader Got %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	km1, s1 := backend(t, conf, "akem1", func(km *kingsmoot.Kingsmoot, w http.ResponseWriter, r *http.Request) {
+		km.StepDown(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer s1.Close()
+	defer km1.Exit()
+	awaitLeader(t, pt, s1.URL, "4")
+	km2, s2 := backend(t, conf, "akem2", nil)
+	defer s2.Close()
+	defer km2.Exit()
+	resp, err = client.Post(front.URL+"/path", "text/plain", strings.NewReader("write"))
+	if err != nil {
+		t.Fatal("5:Request failed", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "akem2 /path write" {
+		t.Fatalf("6:Expected request to be retried on akem2 Got %v %s", resp.StatusCode, body)
+	}
+}
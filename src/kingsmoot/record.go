@@ -0,0 +1,63 @@
+package kingsmoot
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+// Version of the library, written into the LeaderRecord of every leader
+const Version = "0.2.0"
+
+// recordVersion is the version of the encoding of LeaderRecord in the leader key
+const recordVersion = 1
+
+// LeaderRecord is what the leader writes into the leader key. Leaders running versions
+// of the library older than LeaderRecord write only their endpoint, which is read back
+// as a LeaderRecord with just Endpoint and Term.
+type LeaderRecord struct {
+	Version        int               `json:"version"`
+	Endpoint       string            `json:"endpoint"`
+	Hostname       string            `json:"hostname,omitempty"`
+	Pid            int               `json:"pid,omitempty"`
+	AcquiredAt     time.Time         `json:"acquiredAt"`
+	LibraryVersion string            `json:"libraryVersion,omitempty"`
+	Priority       int               `json:"priority,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	// Term is the fencing token of the leader key. It is only known once the key has been
+	// written, so it is not part of the encoding but filled in from the datastore.
+	Term uint64 `json:"-"`
+}
+
+func newLeaderRecord(conf *Config, endpoint string, priority int) *LeaderRecord {
+	hostname, _ := os.Hostname()
+	return &LeaderRecord{
+		Version:        recordVersion,
+		Endpoint:       endpoint,
+		Hostname:       hostname,
+		Pid:            os.Getpid(),
+		AcquiredAt:     conf.clock().Now().UTC(),
+		LibraryVersion: Version,
+		Priority:       priority,
+		Labels:         conf.Labels}
+}
+
+func (r *LeaderRecord) encode() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// decodeLeaderRecord reads the value of the leader key along with its fencing token. An
+// empty value means there is no leader, and gives an empty LeaderRecord.
+func decodeLeaderRecord(value string, token uint64) LeaderRecord {
+	if value == "" {
+		return LeaderRecord{}
+	}
+	var r LeaderRecord
+	if !strings.HasPrefix(value, "{") || json.Unmarshal([]byte(value), &r) != nil || r.Version < 1 || r.Endpoint == "" {
+		r = LeaderRecord{Endpoint: value}
+	}
+	r.Term = token
+	return r
+}
@@ -0,0 +1,81 @@
+package kingsmoot_test
+
+import (
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLeaderRecord(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	conf.Labels = map[string]string{"zone": "in-south-1"}
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "1:Failed to create kingsmoot")
+	start := time.Now()
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	record, err := km1.LeaderRecord()
+	assertNil(t, err, "4:Failed to get leader record")
+	hostname, _ := os.Hostname()
+	if record.Endpoint != c1.endpoint || record.Hostname != hostname || record.Pid != os.Getpid() ||
+		record.LibraryVersion != kingsmoot.Version || record.Labels["zone"] != "in-south-1" {
+		t.Fatalf("5:Unexpected leader record %+v", record)
+	}
+	if record.Term == 0 || record.Term != c1.term() {
+		t.Fatalf("6:Expected term %v in leader record, Got %v", c1.term(), record.Term)
+	}
+	if record.AcquiredAt.Before(start.Add(-time.Second)) || record.AcquiredAt.After(time.Now()) {
+		t.Fatalf("7:Leader record acquired at %v, expected after %v", record.AcquiredAt, start)
+	}
+	c2 := CreateCandidate("akem2:6379")
+	km2, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "8:Failed to create kingsmoot")
+	assertNil(t, km2.Join(c2.endpoint, c2), "9:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "10")
+	c2.mu.Lock()
+	followed := c2.memberShip.Record
+	c2.mu.Unlock()
+	if followed.Endpoint != c1.endpoint || followed.Pid != os.Getpid() || followed.Term != record.Term {
+		t.Fatalf("11:Follower should have got the leader record %+v, Got %+v", record, followed)
+	}
+}
+
+func TestPlainLeaderRecord(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	ds, err := kingsmoot.CreateDatastore(conf)
+	assertNil(t, err, "1:Failed to create ds")
+	defer ds.Close()
+	// Leader key as written by an older node
+	_, token, err := ds.PutIfAbsent(context.Background(), conf.Name, "akem0:6379", 5*time.Second)
+	assertNil(t, err, "2:Failed to write leader key")
+
+	c1 := CreateCandidate("akem1:6379")
+	km1, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "3:Failed to create kingsmoot")
+	assertNil(t, km1.Join(c1.endpoint, c1), "4:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Follower, 20*time.Millisecond, "5")
+	record, err := km1.LeaderRecord()
+	assertNil(t, err, "6:Failed to get leader record")
+	if record.Endpoint != "akem0:6379" || record.Version != 0 || record.Term != token {
+		t.Fatalf("7:Unexpected leader record %+v", record)
+	}
+
+	// The older node restarts with this version and takes its leadership back
+	c0 := CreateCandidate("akem0:6379")
+	km0, err := kingsmoot.NewFromConf(conf)
+	assertNil(t, err, "8:Failed to create kingsmoot")
+	assertNil(t, km0.Join(c0.endpoint, c0), "9:Failed to join leader election")
+	defer km0.Exit()
+	awaitState(t, c0.roleCh, kingsmoot.Leader, 20*time.Millisecond, "10")
+	if c0.term() != token {
+		t.Fatalf("11:Expected term %v, Got %v", token, c0.term())
+	}
+	km0.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 100*time.Millisecond, "12")
+}
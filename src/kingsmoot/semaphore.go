@@ -0,0 +1,159 @@
+package kingsmoot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Semaphore lets up to n holders at a time across processes, for bounding how many of them
+// run something at once. Every holder or waiter keeps a key alive under the prefix
+// Config.Name + ".semaphore/", and the n keys created first hold the semaphore; so waiters
+// get it in the order they asked for it. A Semaphore is held by one goroutine at a time.
+type Semaphore struct {
+	conf      *Config
+	ds        DataStore
+	n         int
+	mu        sync.Mutex //Protects everything below
+	acquiring bool
+	ls        *lease //Lease of the key since the semaphore was last acquired
+}
+
+func NewSemaphore(conf *Config, n int) (*Semaphore, error) {
+	return NewSemaphoreContext(context.Background(), conf, n)
+}
+
+// NewSemaphoreContext is NewSemaphore with ctx bounding the connection to the datastore
+func NewSemaphoreContext(ctx context.Context, conf *Config, n int) (*Semaphore, error) {
+	if err := checkHolders(n); err != nil {
+		return nil, err
+	}
+	ds, err := CreateDatastoreContext(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return newSemaphore(conf, ds, n), nil
+}
+
+func checkHolders(n int) error {
+	if n < 1 {
+		return &InvalidArgumentError{code: InvalidArgument, Name: "n", Value: fmt.Sprint(n), Expected: "At least 1 holder"}
+	}
+	return nil
+}
+
+func newSemaphore(conf *Config, ds DataStore, n int) *Semaphore {
+	return &Semaphore{conf: conf, ds: ds, n: n}
+}
+
+func (s *Semaphore) prefix() string {
+	return s.conf.Name + ".semaphore/"
+}
+
+// Acquire blocks till the semaphore is acquired or ctx is done, and returns the fencing
+// token of the key of the holder
+func (s *Semaphore) Acquire(ctx context.Context) (uint64, error) {
+	s.mu.Lock()
+	if s.acquiring || (s.ls != nil && !s.ls.ended()) {
+		s.mu.Unlock()
+		return 0, errors.New(fmt.Sprintf("Semaphore %v is already held", s.conf.Name))
+	}
+	s.acquiring = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.acquiring = false
+		s.mu.Unlock()
+	}()
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	listener := &KeyChangeListener{changeCh: make(chan *Change, 1), errCh: make(chan error, 1)}
+	if err := s.ds.WatchPrefix(watchCtx, s.prefix(), listener); err != nil {
+		return 0, err
+	}
+	value := newLeaseValue()
+	ls, token, err := acquireLease(ctx, s.conf, s.ds, s.prefix()+value, value)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		admitted, err := s.admitted(ctx, ls.key, token)
+		if err != nil {
+			ls.release(context.Background())
+			return 0, err
+		}
+		if admitted {
+			s.mu.Lock()
+			s.ls = ls
+			s.mu.Unlock()
+			return token, nil
+		}
+		select {
+		case <-listener.changeCh:
+		case <-listener.errCh:
+		case <-s.conf.clock().After(s.conf.MasterDownAfter / 2):
+		case <-ls.done:
+			return 0, &OpError{code: DataStoreError, op: "Acquire", cause: errors.New("Lost the place in the queue of waiters")}
+		case <-ctx.Done():
+			ls.release(context.Background())
+			return 0, ctxError("Acquire", ctx)
+		}
+	}
+}
+
+// admitted tells if fewer than n of the keys under the prefix were created before the key
+// of this waiter
+func (s *Semaphore) admitted(ctx context.Context, key string, token uint64) (bool, error) {
+	values, err := s.ds.List(ctx, s.prefix())
+	if err != nil {
+		return false, err
+	}
+	ahead := 0
+	for other, kv := range values {
+		if other != key && kv.Token < token {
+			ahead++
+		}
+	}
+	return ahead < s.n, nil
+}
+
+// Done is closed once the semaphore is no more held, be it on Release or on failing to
+// renew the key of the holder
+func (s *Semaphore) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ls == nil {
+		return closedCh
+	}
+	return s.ls.done
+}
+
+func (s *Semaphore) Release() error {
+	return s.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is Release with ctx bounding the deletion of the key
+func (s *Semaphore) ReleaseContext(ctx context.Context) error {
+	s.mu.Lock()
+	ls := s.ls
+	s.ls = nil
+	s.mu.Unlock()
+	if ls == nil || ls.ended() {
+		return errors.New(fmt.Sprintf("Semaphore %v is not held", s.conf.Name))
+	}
+	return ls.release(ctx)
+}
+
+// Close releases the semaphore if it is held, and closes the connection to the datastore
+func (s *Semaphore) Close() error {
+	s.mu.Lock()
+	ls := s.ls
+	s.ls = nil
+	s.mu.Unlock()
+	if ls != nil {
+		ls.release(context.Background())
+	}
+	return s.ds.Close()
+}
@@ -0,0 +1,68 @@
+package kingsmoot_test
+
+import (
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	conf := testMemoryConf(t.Name())
+	_, err := kingsmoot.NewSemaphore(conf, 0)
+	assertNotNil(t, err, "1:Semaphore should need at least one holder")
+	var sems []*kingsmoot.Semaphore
+	for i := 0; i < 3; i++ {
+		s, err := kingsmoot.NewSemaphore(conf, 2)
+		assertNil(t, err, "2:Failed to create semaphore")
+		defer s.Close()
+		sems = append(sems, s)
+	}
+	_, err = sems[0].Acquire(context.Background())
+	assertNil(t, err, "3:Failed to acquire")
+	_, err = sems[1].Acquire(context.Background())
+	assertNil(t, err, "4:Failed to acquire")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = sems[2].Acquire(ctx)
+	assertNotNil(t, err, "5:Acquire should have timed out")
+	if err.(kingsmoot.Error).Code() != kingsmoot.Timeout {
+		t.Fatalf("5:Expected %v Got %v", kingsmoot.Timeout, err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := sems[2].Acquire(context.Background())
+		acquired <- err
+	}()
+	// Holders keep the semaphore past its TTL
+	select {
+	case err := <-acquired:
+		t.Fatalf("6:Semaphore should have been full, Got %v", err)
+	case <-time.After(2 * conf.MasterDownAfter):
+	}
+	done := sems[0].Done()
+	assertNil(t, sems[0].Release(), "7:Failed to release")
+	select {
+	case <-done:
+	default:
+		t.Fatal("8:Done should have been closed on Release")
+	}
+	select {
+	case err := <-acquired:
+		assertNil(t, err, "9:Failed to acquire")
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("9:Semaphore should have been acquired on Release")
+	}
+	assertNotNil(t, sems[0].Release(), "10:Release of a semaphore not held should fail")
+}
+
+func TestSemaphoreDeadline(t *testing.T) {
+	conf, clock := stuckRefreshConf(t)
+	s, err := kingsmoot.NewSemaphore(conf, 1)
+	assertNil(t, err, "1:Failed to create semaphore")
+	defer s.Close()
+	_, err = s.Acquire(context.Background())
+	assertNil(t, err, "2:Failed to acquire")
+	awaitDeadline(t, clock, s.Done())
+}
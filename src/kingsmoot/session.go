@@ -0,0 +1,194 @@
+package kingsmoot
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Session is one connection to the datastore hosting any number of elections, observers,
+// locks, semaphores and partitioned elections of a process. They share the client of the
+// datastore along with its background goroutines, and watches of the same key, or of the
+// same prefix, share one watch on the datastore. On etcdv3 their keys also share a lease,
+// kept alive once for all of them when several are refreshed together, like the Session of
+// etcd's concurrency package. Keys are still deleted on StepDown, Exit and Unlock, and the
+// lease is revoked on Close.
+type Session struct {
+	conf *Config
+	ds   *sharedDataStore
+	conn DataStore
+}
+
+func NewSession(conf *Config) (*Session, error) {
+	return NewSessionContext(context.Background(), conf)
+}
+
+// NewSessionContext is NewSession with ctx bounding the connection to the datastore.
+// conf.Name labels the metrics of the connection, elections and the rest are named as they
+// are created.
+func NewSessionContext(ctx context.Context, conf *Config) (*Session, error) {
+	conn, err := CreateDatastoreContext(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	if ls, ok := conn.(leaseSharer); ok {
+		ls.shareLeases()
+	}
+	mux := &muxDataStore{DataStore: conn, watches: make(map[string]*muxWatch)}
+	return &Session{conf: conf, ds: &sharedDataStore{DataStore: mux}, conn: conn}, nil
+}
+
+// confFor is the Config of the Session for what is named name
+func (s *Session) confFor(name string) *Config {
+	conf := *s.conf
+	conf.Name = name
+	return &conf
+}
+
+// Election returns a Kingsmoot of the election name, to Join or Observe. Exit leaves the
+// election without closing the Session.
+func (s *Session) Election(name string) *Kingsmoot {
+	return newKingsmoot(s.confFor(name), s.ds)
+}
+
+func (s *Session) Lock(name string) *Lock {
+	return newLock(s.confFor(name), s.ds)
+}
+
+func (s *Session) Semaphore(name string, n int) (*Semaphore, error) {
+	if err := checkHolders(n); err != nil {
+		return nil, err
+	}
+	return newSemaphore(s.confFor(name), s.ds, n), nil
+}
+
+func (s *Session) PartitionedElection(name string, partitions int) (*PartitionedElection, error) {
+	if err := checkPartitions(partitions); err != nil {
+		return nil, err
+	}
+	return newPartitionedElection(s.confFor(name), s.ds, partitions), nil
+}
+
+// Close closes the connection to the datastore, ending every watch of the Session. What
+// was created from the Session should have exited by then.
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// leaseSharer is a DataStore which can attach the keys written through it to leases
+// shared by all of them, for a Session
+type leaseSharer interface {
+	shareLeases()
+}
+
+// muxDataStore shares one watch on the datastore among all the Listeners of a key or prefix
+type muxDataStore struct {
+	DataStore
+	mu      sync.Mutex //Protects watches
+	watches map[string]*muxWatch
+}
+
+// muxWatch is a watch on the datastore handing changes over to every Listener of it. It
+// is cancelled once it has no Listeners left.
+type muxWatch struct {
+	mds       *muxDataStore
+	id        string
+	cancel    context.CancelFunc
+	ended     chan struct{} //Closed on Bye
+	mu        sync.Mutex    //Protects listeners and nextID
+	listeners map[int]Listener
+	nextID    int
+}
+
+func (mds *muxDataStore) Watch(ctx context.Context, key string, l Listener) error {
+	return mds.watch(ctx, "key:"+key, l, func(ctx context.Context, w *muxWatch) error {
+		return mds.DataStore.Watch(ctx, key, w)
+	})
+}
+
+func (mds *muxDataStore) WatchPrefix(ctx context.Context, prefix string, l Listener) error {
+	return mds.watch(ctx, "prefix:"+prefix, l, func(ctx context.Context, w *muxWatch) error {
+		return mds.DataStore.WatchPrefix(ctx, prefix, w)
+	})
+}
+
+func (mds *muxDataStore) watch(ctx context.Context, id string, l Listener, start func(ctx context.Context, w *muxWatch) error) error {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+	w, ok := mds.watches[id]
+	if !ok {
+		var watchCtx context.Context
+		w = &muxWatch{mds: mds, id: id, ended: make(chan struct{}), listeners: make(map[int]Listener)}
+		watchCtx, w.cancel = context.WithCancel(context.Background())
+		if err := start(watchCtx, w); err != nil {
+			w.cancel()
+			return err
+		}
+		mds.watches[id] = w
+	}
+	w.mu.Lock()
+	lid := w.nextID
+	w.nextID++
+	w.listeners[lid] = l
+	w.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			if w.remove(lid) {
+				l.Bye(&OpError{code: DataStoreError, op: "Watch", cause: ctx.Err()})
+			}
+		case <-w.ended:
+		}
+	}()
+	return nil
+}
+
+// remove lets go of the Listener lid, and of the watch on the datastore if it was the last
+// one. It tells if lid was still a Listener of the watch.
+func (w *muxWatch) remove(lid int) bool {
+	w.mds.mu.Lock()
+	defer w.mds.mu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.listeners[lid]; !ok {
+		return false
+	}
+	delete(w.listeners, lid)
+	if len(w.listeners) == 0 {
+		if w.mds.watches[w.id] == w {
+			delete(w.mds.watches, w.id)
+		}
+		w.cancel()
+	}
+	return true
+}
+
+func (w *muxWatch) Notify(change *Change) {
+	w.mu.Lock()
+	listeners := make([]Listener, 0, len(w.listeners))
+	for _, l := range w.listeners {
+		listeners = append(listeners, l)
+	}
+	w.mu.Unlock()
+	for _, l := range listeners {
+		l.Notify(change)
+	}
+}
+
+// Bye ends the watch for every Listener, a Listener watching again gets a new one
+func (w *muxWatch) Bye(err error) {
+	w.mds.mu.Lock()
+	if w.mds.watches[w.id] == w {
+		delete(w.mds.watches, w.id)
+	}
+	w.mds.mu.Unlock()
+	w.mu.Lock()
+	listeners := w.listeners
+	w.listeners = make(map[int]Listener)
+	w.mu.Unlock()
+	w.cancel()
+	close(w.ended)
+	for _, l := range listeners {
+		l.Bye(err)
+	}
+}
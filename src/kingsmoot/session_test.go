@@ -0,0 +1,72 @@
+package kingsmoot_test
+
+import (
+	"golang.org/x/net/context"
+	"kingsmoot"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingDataStore counts the watches set up on the datastore
+type countingDataStore struct {
+	kingsmoot.DataStore
+	watches *int32
+}
+
+func (c *countingDataStore) Watch(ctx context.Context, key string, l kingsmoot.Listener) error {
+	atomic.AddInt32(c.watches, 1)
+	return c.DataStore.Watch(ctx, key, l)
+}
+
+func TestSession(t *testing.T) {
+	var connections, watches int32
+	kingsmoot.Register("counting", func(ctx context.Context, conf *kingsmoot.Config) (kingsmoot.DataStore, error) {
+		atomic.AddInt32(&connections, 1)
+		ds, err := kingsmoot.NewMemoryDataStore(ctx, conf)
+		return &countingDataStore{DataStore: ds, watches: &watches}, err
+	})
+	conf := testMemoryConf(t.Name())
+	conf.DataStoreType = "counting"
+	s, err := kingsmoot.NewSession(conf)
+	assertNil(t, err, "1:Failed to create session")
+	defer s.Close()
+
+	c1 := CreateCandidate("akem1:6379")
+	km1 := s.Election("akem")
+	assertNil(t, km1.Join(c1.endpoint, c1), "2:Failed to join leader election")
+	defer km1.Exit()
+	awaitState(t, c1.roleCh, kingsmoot.Leader, 20*time.Millisecond, "3")
+	c2 := CreateCandidate("akem2:6379")
+	km2 := s.Election("akem")
+	assertNil(t, km2.Join(c2.endpoint, c2), "4:Failed to join leader election")
+	defer km2.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Follower, 20*time.Millisecond, "5")
+	c3 := CreateCandidate("akem3:6379")
+	km3 := s.Election("other")
+	assertNil(t, km3.Join(c3.endpoint, c3), "6:Failed to join leader election")
+	defer km3.Exit()
+	awaitState(t, c3.roleCh, kingsmoot.Leader, 20*time.Millisecond, "7")
+	if n := atomic.LoadInt32(&watches); n != 2 {
+		t.Fatalf("8:Expected a watch per election, Got %v watches", n)
+	}
+
+	// The watch goes on for the follower once the leader exits
+	km1.Exit()
+	awaitState(t, c2.roleCh, kingsmoot.Leader, 100*time.Millisecond, "9")
+	leader, err := km3.Leader()
+	assertNil(t, err, "10:Session should have stayed open on Exit")
+	if leader != c3.endpoint {
+		t.Fatalf("10:Expected leader %v Got %v", c3.endpoint, leader)
+	}
+
+	lock := s.Lock("akem.lock")
+	_, err = lock.TryLock(context.Background())
+	assertNil(t, err, "11:Failed to lock")
+	assertNil(t, lock.Unlock(), "12:Failed to unlock")
+	_, err = s.Semaphore("akem.sem", 0)
+	assertNotNil(t, err, "13:Semaphore should need at least one holder")
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Fatalf("14:Expected one connection for the session, Got %v", n)
+	}
+}
@@ -0,0 +1,234 @@
+// Package sim runs the election of several Kingsmoot instances against an in-process
+// datastore on simulated time, for tests of failover timing, overlapping leaders and
+// flapping which take milliseconds and do not depend on the load of the machine.
+//
+// Time only moves on Run, a Step at a time. Timers fire at the time they are due, in
+// order, and the goroutines they wake are let settle before the next Step, so what the
+// nodes do is seen at the granularity of a Step. Latency and delays injected into the
+// ChaosDataStore of a node are on simulated time too.
+package sim
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"kingsmoot"
+)
+
+const (
+	settlePoll  = 200 * time.Microsecond
+	settlePolls = 3
+)
+
+var simIDs uint64
+
+// Simulator is an election of nodes sharing a datastore and a FakeClock
+type Simulator struct {
+	Clock *kingsmoot.FakeClock
+	// Step is how far Run moves time at once, a thirtieth of MasterDownAfter unless set
+	Step       time.Duration
+	start      time.Time
+	nodes      []*Node
+	mu         sync.Mutex //Protects everything below
+	overlap    time.Duration
+	changes    int
+	lastLeader string
+}
+
+// Node is a candidate of the election, named node<i>. Its DataStore is its own, so faults
+// can be injected into it alone.
+type Node struct {
+	Endpoint  string
+	Kingsmoot *kingsmoot.Kingsmoot
+	DataStore *kingsmoot.ChaosDataStore
+	sim       *Simulator
+	mu        sync.Mutex //Protects everything below
+	role      kingsmoot.Role
+	history   []Transition
+}
+
+// Transition is a MemberShip a node was told about, and when in simulated time
+type Transition struct {
+	At time.Duration
+	kingsmoot.MemberShip
+}
+
+// Option changes how a Simulator is set up
+type Option func(o *options)
+
+type options struct {
+	seed int64
+}
+
+// WithSeed seeds the faults injected at a rate into the ChaosDataStores of the nodes, 1 by
+// default. With the same seed, the n-th operation of a node fails the same way in every
+// run, though which node wins a race within a Step is still up to the Go scheduler.
+func WithSeed(seed int64) Option {
+	return func(o *options) {
+		o.seed = seed
+	}
+}
+
+// New creates n nodes of the election conf.Name with the timings of conf, which have yet
+// to Join. The datastore and clock of conf are replaced by those of the Simulator.
+func New(conf *kingsmoot.Config, n int, opts ...Option) (*Simulator, error) {
+	o := options{seed: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Simulator{Clock: kingsmoot.NewFakeClock(start), Step: conf.MasterDownAfter / 30, start: start}
+	simConf := *conf
+	simConf.DataStoreType = "memory"
+	simConf.Addresses = []string{fmt.Sprintf("sim-%v", atomic.AddUint64(&simIDs, 1))}
+	simConf.Clock = s.Clock
+	for i := 0; i < n; i++ {
+		ds, err := kingsmoot.NewMemoryDataStore(context.Background(), &simConf)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		cds := kingsmoot.NewChaosDataStore(ds, kingsmoot.ChaosClock(s.Clock), kingsmoot.ChaosSeed(o.seed+int64(i)))
+		s.nodes = append(s.nodes, &Node{Endpoint: fmt.Sprintf("node%v", i), Kingsmoot: kingsmoot.NewFromDataStore(&simConf, cds), DataStore: cds, sim: s})
+	}
+	return s, nil
+}
+
+func (s *Simulator) Nodes() []*Node {
+	return s.nodes
+}
+
+// Elapsed is the simulated time since the Simulator was created
+func (s *Simulator) Elapsed() time.Duration {
+	return s.Clock.Now().Sub(s.start)
+}
+
+// Run moves time forward by d, a Step at a time
+func (s *Simulator) Run(d time.Duration) {
+	s.RunUntil(func() bool { return false }, d)
+}
+
+// RunUntil moves time forward a Step at a time till done is true, or for up to max. It
+// returns the simulated time it ran for, and whether done came true.
+func (s *Simulator) RunUntil(done func() bool, max time.Duration) (time.Duration, bool) {
+	var elapsed time.Duration
+	for elapsed < max {
+		step := s.Step
+		if step > max-elapsed {
+			step = max - elapsed
+		}
+		s.Clock.Advance(step)
+		s.settle()
+		s.observe(step)
+		elapsed += step
+		if done() {
+			return elapsed, true
+		}
+	}
+	return elapsed, false
+}
+
+// settle waits for the goroutines woken by the last Step to be done with it, taken to be
+// when the timers pending and the transitions of the nodes stay the same for a few polls
+func (s *Simulator) settle() {
+	prev, stable := -1, 0
+	for stable < settlePolls {
+		time.Sleep(settlePoll)
+		curr := s.Clock.Pending()
+		for _, n := range s.nodes {
+			n.mu.Lock()
+			curr += len(n.history) << 16
+			n.mu.Unlock()
+		}
+		if curr == prev {
+			stable++
+		} else {
+			prev, stable = curr, 0
+		}
+	}
+}
+
+func (s *Simulator) observe(step time.Duration) {
+	leaders := s.Leaders()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(leaders) > 1 {
+		s.overlap += step
+	}
+	if len(leaders) == 1 && leaders[0] != s.lastLeader {
+		if s.lastLeader != "" {
+			s.changes++
+		}
+		s.lastLeader = leaders[0]
+	}
+}
+
+// Leaders returns the endpoints of the nodes which take themselves to be Leader, which
+// should never be more than one
+func (s *Simulator) Leaders() []string {
+	var leaders []string
+	for _, n := range s.nodes {
+		if n.Role() == kingsmoot.Leader {
+			leaders = append(leaders, n.Endpoint)
+		}
+	}
+	return leaders
+}
+
+// Overlap is the simulated time for which more than one node took itself to be Leader
+func (s *Simulator) Overlap() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.overlap
+}
+
+// LeaderChanges is the number of times leadership moved from one node to another
+func (s *Simulator) LeaderChanges() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changes
+}
+
+// Close exits every node
+func (s *Simulator) Close() {
+	for _, n := range s.nodes {
+		n.Kingsmoot.Exit()
+	}
+}
+
+// Join joins the node to the election and lets the nodes settle
+func (n *Node) Join(opts ...kingsmoot.JoinOption) error {
+	if err := n.Kingsmoot.Join(n.Endpoint, n, opts...); err != nil {
+		return err
+	}
+	n.sim.settle()
+	return nil
+}
+
+func (n *Node) UpdateMembership(memberShip kingsmoot.MemberShip) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.role = memberShip.Role
+	n.history = append(n.history, Transition{At: n.sim.Elapsed(), MemberShip: memberShip})
+	return nil
+}
+
+func (n *Node) Role() kingsmoot.Role {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role
+}
+
+// History returns every MemberShip the node was told about, in order
+func (n *Node) History() []Transition {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Transition(nil), n.history...)
+}
+
+func (n *Node) String() string {
+	return n.Endpoint
+}
@@ -0,0 +1,92 @@
+package sim_test
+
+import (
+	"errors"
+	"kingsmoot"
+	"kingsmoot/sim"
+	"testing"
+	"time"
+)
+
+func testConf() *kingsmoot.Config {
+	return &kingsmoot.Config{
+		Name:            "akem",
+		DsOpTimeout:     500 * time.Millisecond,
+		MasterDownAfter: 30 * time.Second}
+}
+
+func newSimulator(t *testing.T, n int) *sim.Simulator {
+	s, err := sim.New(testConf(), n)
+	if err != nil {
+		t.Fatal("Failed to create simulator", err)
+	}
+	for _, node := range s.Nodes() {
+		if err := node.Join(); err != nil {
+			t.Fatal("Failed to join leader election", err)
+		}
+	}
+	return s
+}
+
+func TestFailover(t *testing.T) {
+	s := newSimulator(t, 3)
+	defer s.Close()
+	s.Run(time.Second)
+	if leaders := s.Leaders(); len(leaders) != 1 || leaders[0] != "node0" {
+		t.Fatalf("1:Expected node0 to lead Got %v", leaders)
+	}
+	node0 := s.Nodes()[0]
+
+	// The partition ends the watch of node0, which looks at the election right away and
+	// gives up as its refresh fails. Its key was refreshed at 0s, so it expires at 30s, when
+	// another node takes over.
+	node0.DataStore.Partition()
+	elapsed, ok := s.RunUntil(func() bool {
+		leaders := s.Leaders()
+		return len(leaders) == 1 && leaders[0] != "node0"
+	}, time.Minute)
+	if !ok {
+		t.Fatalf("2:No other node took over within a minute, leaders are %v", s.Leaders())
+	}
+	history := node0.History()
+	if last := history[len(history)-1]; last.Role != kingsmoot.NotAMember || last.At > 2*time.Second {
+		t.Fatalf("3:Expected node0 to give up at once Got %+v", last)
+	}
+	if failover := time.Second + elapsed; failover != 30*time.Second {
+		t.Fatalf("4:Expected failover when the key expired at 30s Got %v", failover)
+	}
+	if s.Overlap() != 0 || s.LeaderChanges() != 1 {
+		t.Fatalf("5:Expected one change of leader and no overlap Got %v %v", s.LeaderChanges(), s.Overlap())
+	}
+
+	node0.DataStore.Heal()
+	s.Run(time.Minute)
+	if node0.Role() != kingsmoot.Follower || s.LeaderChanges() != 1 {
+		t.Fatalf("6:Expected node0 to follow once healed Got %v after %v changes", node0.Role(), s.LeaderChanges())
+	}
+}
+
+func TestFlakyRefresh(t *testing.T) {
+	s := newSimulator(t, 3)
+	defer s.Close()
+	for _, node := range s.Nodes() {
+		node.DataStore.Fail(kingsmoot.Timeout, 0.5, "RefreshTTL")
+	}
+	s.Run(10 * time.Minute)
+	if s.Overlap() != 0 || s.LeaderChanges() == 0 {
+		t.Fatalf("Expected leadership to flap without overlap Got %v changes and %v overlap", s.LeaderChanges(), s.Overlap())
+	}
+}
+
+func TestWatchEnded(t *testing.T) {
+	s := newSimulator(t, 3)
+	defer s.Close()
+	s.Run(time.Second)
+	node0 := s.Nodes()[0]
+	// The leader goes on refreshing its key while its watch is down
+	node0.DataStore.ByeWatches(errors.New("Injected"))
+	s.Run(time.Minute)
+	if leaders := s.Leaders(); len(leaders) != 1 || leaders[0] != "node0" || s.LeaderChanges() != 0 {
+		t.Fatalf("Expected node0 to stay leader Got %v after %v changes", leaders, s.LeaderChanges())
+	}
+}
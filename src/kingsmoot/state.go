@@ -0,0 +1,184 @@
+package kingsmoot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// transitions lists the roles a candidate can move to from each role. Follower to Follower
+// is how a change of leader is passed on to the Candidate.
+var transitions = map[Role][]Role{
+	NotAMember: {Follower, Leader, Dead},
+	Follower:   {NotAMember, Follower, Leader, Dead},
+	Leader:     {NotAMember, Follower, Dead},
+	Dead:       {},
+}
+
+func canTransition(from Role, to Role) bool {
+	for _, role := range transitions[from] {
+		if role == to {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the role and endpoint of the candidate
+func (km *Kingsmoot) current() (Role, string) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	return km.role, km.endpoint
+}
+
+// transition moves the candidate to role, and queues telling the Candidate about it. If
+// the Candidate fails to take up Leader or Follower, it is kicked out of the election
+// instead; if it fails to take up NotAMember, it is out of the election all the same.
+// Must be called with km.mu held.
+func (km *Kingsmoot) transition(to Role, record LeaderRecord) error {
+	from := km.role
+	if from == NotAMember && to == NotAMember {
+		return nil
+	}
+	if !canTransition(from, to) {
+		return &OpError{code: IllegalTransition, op: "Transition", cause: errors.New(fmt.Sprintf("%v can not move from %v to %v", km.c, from, to))}
+	}
+	if to != Leader {
+		km.stopLease()
+	}
+	if to == Dead {
+		if from == Leader {
+			leaderSeconds.observe(km.conf.clock().Now().Sub(km.leaderSince).Seconds(), km.conf.Name)
+		}
+		km.role, km.currLeader, km.term, km.currRecord = Dead, "", 0, LeaderRecord{}
+		km.updateLogger()
+		km.publish(RoleChanged, nil)
+		km.events.close()
+		km.calls.close()
+		return nil
+	}
+	if from != Leader && to == Leader {
+		km.leaderSince = km.conf.clock().Now()
+	} else if from == Leader && to != Leader {
+		leaderSeconds.observe(km.conf.clock().Now().Sub(km.leaderSince).Seconds(), km.conf.Name)
+	}
+	prevLeader, prevTerm := km.currLeader, km.term
+	km.role, km.currLeader, km.term, km.currRecord = to, record.Endpoint, record.Term, record
+	km.updateLogger()
+	if from != to {
+		km.publish(RoleChanged, nil)
+	}
+	if record.Endpoint != prevLeader || record.Term != prevTerm {
+		km.publish(LeaderChanged, nil)
+	}
+	c, memberShip := km.c, MemberShip{Role: to, Leader: record.Endpoint, Term: record.Term, Record: record}
+	km.calls.push(func() {
+		if err := c.UpdateMembership(memberShip); err != nil {
+			km.refused(memberShip, err)
+		}
+	})
+	return nil
+}
+
+// refused kicks the candidate out of the election if the Candidate failed to take up
+// memberShip and it still holds. A candidate refusing Leader gives the leader key up for
+// the others to take, and does not campaign again till Resume.
+func (km *Kingsmoot) refused(memberShip MemberShip, err error) {
+	km.mu.Lock()
+	if memberShip.Role == NotAMember {
+		// Nowhere further to go, the candidate is out of the election either way
+		km.log().Errorf("Failed to update membership of %v due %v", km.c, err)
+		km.mu.Unlock()
+		return
+	}
+	if km.role != memberShip.Role || km.currLeader != memberShip.Leader || km.term != memberShip.Term {
+		km.mu.Unlock()
+		return
+	}
+	km.log().Warnf("%v Failed to start as %v due to %v, going to kick out from election", km.c, memberShip.Role, err)
+	km.notAMember()
+	if memberShip.Role != Leader {
+		km.mu.Unlock()
+		return
+	}
+	km.refusedLead = true
+	value := km.value
+	km.mu.Unlock()
+	if err := km.ds.CompareAndDel(km.ctx, km.conf.Name, value); err != nil {
+		km.log().Warnf("Failed to give up leader key of %v due to %v", km.conf.Name, err)
+	}
+}
+
+func (km *Kingsmoot) notAMember() {
+	km.transition(NotAMember, LeaderRecord{})
+}
+
+func (km *Kingsmoot) lead(start time.Time, value string, record LeaderRecord) error {
+	if err := km.transition(Leader, record); err != nil {
+		return err
+	}
+	km.value = value
+	km.log().Infof("%v Elected as leader of %v for term %v", km.c, km.conf.Name, record.Term)
+	km.extendLease(start)
+	return nil
+}
+
+func (km *Kingsmoot) follow(leader LeaderRecord) error {
+	if err := km.transition(Follower, leader); err != nil {
+		return err
+	}
+	km.log().Infof("%v Elected as follower of %v", km.c, leader.Endpoint)
+	return nil
+}
+
+// callQueue makes the calls into the Candidate or Observer in order from its own
+// goroutine, after km.mu is released, so that they can call back into the Kingsmoot. It
+// is closed once the candidate is Dead, after the calls queued till then have been made.
+type callQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	calls  []func()
+	closed bool
+}
+
+func newCallQueue() *callQueue {
+	q := &callQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+func (q *callQueue) push(f func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.calls = append(q.calls, f)
+	q.cond.Signal()
+}
+
+func (q *callQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Signal()
+}
+
+func (q *callQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.calls) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.calls) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		f := q.calls[0]
+		q.calls = q.calls[1:]
+		q.mu.Unlock()
+		f()
+	}
+}